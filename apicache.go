@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// An apiResponse is a cached response of the GitHub API. It is revalidated
+// with its ETag or Last-Modified time, since GitHub does not count requests
+// that return 304 Not Modified against the rate limit.
+type apiResponse struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Time         time.Time `json:"time"` // when it was last validated
+	Body         []byte    `json:"body"`
+}
+
+func apiCachePath(url string) (string, error) {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "api", fmt.Sprintf("%x.json", sha256.Sum256([]byte(url)))), nil
+}
+
+func loadAPIResponse(url string) *apiResponse {
+	p, err := apiCachePath(url)
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	var r apiResponse
+	if json.Unmarshal(data, &r) != nil || r.URL != url {
+		return nil
+	}
+	return &r
+}
+
+func (r *apiResponse) save() error {
+	p, err := apiCachePath(r.URL)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".response-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// response returns the cached response as an http.Response.
+func (r *apiResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// fresh returns true if the response may be used without validating it,
+// because the API cannot be reached.
+func (r *apiResponse) fresh() bool {
+	return opts.APICacheTTL > 0 && time.Since(r.Time) < opts.APICacheTTL
+}
+
+// refused returns true if a response with 'status' means that the API refused
+// the request because of the rate limit or an error on its side.
+func refused(status int) bool {
+	return status == http.StatusForbidden || status == http.StatusTooManyRequests || status >= 500
+}
+
+// unavailable returns true if 'err' means that the API could not be reached
+// or refused the request, rather than that the request is invalid.
+func unavailable(err error) bool {
+	var ge *GithubError
+	if errors.As(err, &ge) {
+		return refused(ge.Code)
+	}
+	return true
+}
+
+// GetAPI is like Get, but for requests to the GitHub API. Responses are
+// cached and revalidated with conditional requests. If the API cannot be
+// reached, or refuses the request because of the rate limit or an error on
+// its side, a cached response that was validated within the TTL is used
+// instead.
+func GetAPI(url string) (*http.Response, error) {
+	if opts.NoCache {
+		return Get(url)
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = SetAuthHeader(req)
+
+	cached := loadAPIResponse(url)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := doMirrors(req)
+	if err != nil {
+		if cached != nil && cached.fresh() {
+			fmt.Fprintf(os.Stderr, "warning: %v; using the response cached at %s\n", err, cached.Time.Format(time.RFC1123))
+			return cached.response(req), nil
+		}
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+		cached.Time = time.Now()
+		cached.save()
+		return cached.response(req), nil
+	case resp.StatusCode == http.StatusOK:
+		etag, modified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if etag == "" && modified == "" {
+			return resp, nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		r := &apiResponse{
+			URL:          url,
+			ETag:         etag,
+			LastModified: modified,
+			Time:         time.Now(),
+			Body:         body,
+		}
+		if err := r.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not cache API response:", err)
+		}
+		return r.response(req), nil
+	case cached != nil && cached.fresh() && refused(resp.StatusCode):
+		resp.Body.Close()
+		fmt.Fprintf(os.Stderr, "warning: %s; using the response cached at %s\n", resp.Status, cached.Time.Format(time.RFC1123))
+		return cached.response(req), nil
+	}
+	return resp, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiServer serves 'body' with an ETag or Last-Modified time, and answers
+// conditional requests for the current version with 304 Not Modified.
+type apiServer struct {
+	sync.Mutex
+	body     string
+	etag     string
+	modified string
+	status   int      // the status of every response, if set
+	headers  []string // the validator sent with each request
+	served   []int    // the status of each response
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.headers = append(s.headers, r.Header.Get("If-None-Match")+r.Header.Get("If-Modified-Since"))
+	status := http.StatusOK
+	switch {
+	case s.status != 0:
+		status = s.status
+	case s.etag != "" && r.Header.Get("If-None-Match") == s.etag,
+		s.modified != "" && r.Header.Get("If-Modified-Since") == s.modified:
+		status = http.StatusNotModified
+	}
+	s.served = append(s.served, status)
+
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+	}
+	if s.modified != "" {
+		w.Header().Set("Last-Modified", s.modified)
+	}
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		io.WriteString(w, s.body)
+	}
+}
+
+func getAPI(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := GetAPI(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestGetAPIRevalidate(t *testing.T) {
+	const modified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	tests := []struct {
+		name      string
+		etag      string
+		modified  string
+		validator string
+	}{
+		{"etag", `"v1"`, "", `"v1"`},
+		{"last modified", "", modified, modified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setOpts(t, Flags{})
+			t.Setenv("EGET_CACHE", t.TempDir())
+			s := &apiServer{body: "release 1", etag: tt.etag, modified: tt.modified}
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+			url := srv.URL + "/repos/user/tool/releases/latest"
+
+			if code, body := getAPI(t, url); code != http.StatusOK || body != "release 1" {
+				t.Fatalf("first response = %d %q", code, body)
+			}
+			cached := loadAPIResponse(url)
+			if cached == nil {
+				t.Fatal("the response was not cached")
+			}
+			validated := cached.Time
+
+			// the cached response is served for 304 Not Modified
+			time.Sleep(10 * time.Millisecond)
+			if code, body := getAPI(t, url); code != http.StatusOK || body != "release 1" {
+				t.Errorf("revalidated response = %d %q", code, body)
+			}
+			if s.headers[0] != "" || s.headers[1] != tt.validator {
+				t.Errorf("validators = %q, want none and then %q", s.headers, tt.validator)
+			}
+			if s.served[1] != http.StatusNotModified {
+				t.Errorf("server responded with %d, want 304", s.served[1])
+			}
+			if c := loadAPIResponse(url); c == nil || !c.Time.After(validated) {
+				t.Error("the time the response was validated was not updated")
+			}
+
+			// a changed response replaces the cached one
+			s.Lock()
+			s.body = "release 2"
+			if s.etag != "" {
+				s.etag = `"v2"`
+			} else {
+				s.modified = "Tue, 03 Jan 2006 15:04:05 GMT"
+			}
+			s.Unlock()
+			if _, body := getAPI(t, url); body != "release 2" {
+				t.Errorf("changed response = %q", body)
+			}
+			if c := loadAPIResponse(url); c == nil || string(c.Body) != "release 2" {
+				t.Error("the changed response was not cached")
+			}
+		})
+	}
+}
+
+func TestGetAPINoValidator(t *testing.T) {
+	setOpts(t, Flags{})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	s := &apiServer{body: "release"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	url := srv.URL + "/repos/user/tool/releases/latest"
+
+	for i := 0; i < 2; i++ {
+		if _, body := getAPI(t, url); body != "release" {
+			t.Errorf("response = %q", body)
+		}
+	}
+	if loadAPIResponse(url) != nil {
+		t.Error("a response without a validator was cached")
+	}
+	if s.headers[1] != "" {
+		t.Errorf("sent validator %q", s.headers[1])
+	}
+}
+
+func TestGetAPIUnavailable(t *testing.T) {
+	setOpts(t, Flags{APICacheTTL: time.Hour})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	s := &apiServer{body: "release", etag: `"v1"`}
+	srv := httptest.NewServer(s)
+	url := srv.URL + "/repos/user/tool/releases/latest"
+	getAPI(t, url)
+
+	// the cached response is used if the API refuses the request
+	s.Lock()
+	s.status = http.StatusForbidden
+	s.Unlock()
+	if code, body := getAPI(t, url); code != http.StatusOK || body != "release" {
+		t.Errorf("refused: response = %d %q", code, body)
+	}
+	// but not for other errors
+	s.Lock()
+	s.status = http.StatusNotFound
+	s.Unlock()
+	if code, _ := getAPI(t, url); code != http.StatusNotFound {
+		t.Errorf("not found: status = %d", code)
+	}
+
+	// it is also used if the API can't be reached
+	srv.Close()
+	if code, body := getAPI(t, url); code != http.StatusOK || body != "release" {
+		t.Errorf("unreachable: response = %d %q", code, body)
+	}
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"strconv"
 	"strings"
 )
 
@@ -17,6 +21,7 @@ const (
 	TypeDir
 	TypeLink
 	TypeSymlink
+	TypeDevice // device node or named pipe
 	TypeOther
 )
 
@@ -30,6 +35,8 @@ func tarft(typ byte) FileType {
 		return TypeLink
 	case tar.TypeSymlink:
 		return TypeSymlink
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return TypeDevice
 	}
 	return TypeOther
 }
@@ -45,18 +52,20 @@ func (f File) Dir() bool {
 	return f.Type == TypeDir
 }
 
+// An Archive reads the files in an archive one at a time. Open returns a
+// reader for the contents of the file most recently returned by Next, which
+// is only valid until the next call to Next.
 type Archive interface {
 	Next() (File, error)
-	ReadAll() ([]byte, error)
+	Open() (io.Reader, error)
 }
 
 type TarArchive struct {
 	r *tar.Reader
 }
 
-func NewTarArchive(data []byte, decompress DecompFn) (Archive, error) {
-	r := bytes.NewReader(data)
-	dr, err := decompress(r)
+func NewTarArchive(src Source, decompress DecompFn) (Archive, error) {
+	dr, err := decompress(sourceReader(src))
 	if err != nil {
 		return nil, err
 	}
@@ -76,27 +85,27 @@ func (t *TarArchive) Next() (File, error) {
 			return File{
 				Name:     hdr.Name,
 				LinkName: hdr.Linkname,
-				Mode:     fs.FileMode(hdr.Mode),
+				Mode:     hdr.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky),
 				Type:     ft,
 			}, err
 		}
 	}
 }
 
-func (t *TarArchive) ReadAll() ([]byte, error) {
-	return io.ReadAll(t.r)
+func (t *TarArchive) Open() (io.Reader, error) {
+	return t.r, nil
 }
 
 type ZipArchive struct {
 	r   *zip.Reader
 	idx int
+	rc  io.ReadCloser // the open file, if any
 }
 
 // decompressor does nothing for a zip archive because it already has built-in
 // compression.
-func NewZipArchive(data []byte, d DecompFn) (Archive, error) {
-	r := bytes.NewReader(data)
-	zr, err := zip.NewReader(r, int64(len(data)))
+func NewZipArchive(src Source, d DecompFn) (Archive, error) {
+	zr, err := zip.NewReader(src, src.Size())
 	return &ZipArchive{
 		r:   zr,
 		idx: -1,
@@ -104,6 +113,10 @@ func NewZipArchive(data []byte, d DecompFn) (Archive, error) {
 }
 
 func (z *ZipArchive) Next() (File, error) {
+	if z.rc != nil {
+		z.rc.Close()
+		z.rc = nil
+	}
 	z.idx++
 
 	if z.idx < 0 || z.idx >= len(z.r.File) {
@@ -124,16 +137,426 @@ func (z *ZipArchive) Next() (File, error) {
 	}, nil
 }
 
-func (z *ZipArchive) ReadAll() ([]byte, error) {
+func (z *ZipArchive) Open() (io.Reader, error) {
 	if z.idx < 0 || z.idx >= len(z.r.File) {
 		return nil, io.EOF
 	}
+	if z.rc != nil {
+		z.rc.Close()
+	}
 	f := z.r.File[z.idx]
 	rc, err := f.Open()
 	if err != nil {
 		return nil, fmt.Errorf("zip extract: %w", err)
 	}
-	defer rc.Close()
-	data, err := io.ReadAll(rc)
-	return data, err
+	z.rc = rc
+	return rc, nil
+}
+
+// arMember returns the contents and name of the first member of the ar
+// archive whose name starts with 'prefix'.
+func arMember(src Source, prefix string) (*io.SectionReader, string, error) {
+	const magic = "!<arch>\n"
+	const hdrsize = 60
+
+	head := make([]byte, len(magic))
+	if _, err := src.ReadAt(head, 0); err != nil || string(head) != magic {
+		return nil, "", errors.New("ar: invalid magic")
+	}
+	off := int64(len(magic))
+	hdr := make([]byte, hdrsize)
+	for off+hdrsize <= src.Size() {
+		if _, err := src.ReadAt(hdr, off); err != nil {
+			return nil, "", fmt.Errorf("ar: %w", err)
+		}
+		if string(hdr[58:60]) != "`\n" {
+			return nil, "", fmt.Errorf("ar: invalid header at offset %d", off)
+		}
+		name := strings.TrimRight(strings.TrimSpace(string(hdr[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || size < 0 || src.Size()-off-hdrsize < size {
+			return nil, "", fmt.Errorf("ar: invalid size for member %s", name)
+		}
+		off += hdrsize
+		if strings.HasPrefix(name, prefix) {
+			return io.NewSectionReader(src, off, size), name, nil
+		}
+		// members are aligned to 2 bytes
+		off += size + size%2
+	}
+	return nil, "", fmt.Errorf("ar: no %s member found", prefix)
+}
+
+// A DebArchive reads the files installed by a Debian package. The package is
+// an ar archive and the installed files are stored in its data.tar member.
+type DebArchive struct {
+	tar *TarArchive
+}
+
+// the decompressor is chosen based on the data.tar member's name so the
+// given one is not used.
+func NewDebArchive(src Source, d DecompFn) (Archive, error) {
+	member, name, err := arMember(src, "data.tar")
+	if err != nil {
+		return nil, fmt.Errorf("deb: %w", err)
+	}
+
+	var decomp DecompFn
+	switch name {
+	case "data.tar":
+		decomp = nounzipper
+	case "data.tar.gz":
+		decomp = gunzipper
+	case "data.tar.xz":
+		decomp = xunzipper
+	case "data.tar.zst":
+		decomp = zstdunzipper
+	case "data.tar.bz2":
+		decomp = b2unzipper
+	default:
+		return nil, fmt.Errorf("deb: unsupported data member %s", name)
+	}
+
+	ar, err := NewTarArchive(member, decomp)
+	if err != nil {
+		return nil, fmt.Errorf("deb: %w", err)
+	}
+	return &DebArchive{
+		tar: ar.(*TarArchive),
+	}, nil
+}
+
+func (d *DebArchive) Next() (File, error) {
+	for {
+		f, err := d.tar.Next()
+		if err != nil {
+			return File{}, err
+		}
+		// package contents are stored relative to the root as './usr/...'
+		f.Name = strings.TrimPrefix(f.Name, "./")
+		if f.Type == TypeLink {
+			f.LinkName = strings.TrimPrefix(f.LinkName, "./")
+		}
+		if f.Name != "" {
+			return f, nil
+		}
+	}
+}
+
+func (d *DebArchive) Open() (io.Reader, error) {
+	return d.tar.Open()
+}
+
+// An entryReader reads the 'n' bytes of data of an archive entry from 'r'.
+type entryReader struct {
+	r io.Reader
+	n int64
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	if e.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > e.n {
+		p = p[:e.n]
+	}
+	n, err := e.r.Read(p)
+	e.n -= int64(n)
+	if err == io.EOF && e.n > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// A CpioArchive reads a cpio archive in the 'newc' format (as used by RPM
+// payloads).
+type CpioArchive struct {
+	r     *bufio.Reader
+	data  entryReader // unread data in the current entry
+	pad   int64       // padding after the current entry's data
+	links map[[3]uint32]*cpioLinks
+	order [][3]uint32 // the keys of 'links' in the order they were found
+	queue []File
+	eof   bool
+}
+
+// cpioLinks is a set of hard links whose data has not been read yet.
+type cpioLinks struct {
+	names []string
+	mode  fs.FileMode
+}
+
+func NewCpioArchive(src Source, decompress DecompFn) (Archive, error) {
+	dr, err := decompress(sourceReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return &CpioArchive{
+		r:     bufio.NewReader(dr),
+		links: make(map[[3]uint32]*cpioLinks),
+	}, nil
+}
+
+// cpio mode bits
+const (
+	cpioTypeMask = 0170000
+	cpioDir      = 0040000
+	cpioReg      = 0100000
+	cpioSymlink  = 0120000
+	cpioChar     = 0020000
+	cpioBlock    = 0060000
+	cpioFifo     = 0010000
+	cpioSetuid   = 04000
+	cpioSetgid   = 02000
+	cpioSticky   = 01000
+)
+
+func cpioMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0777)
+	if mode&cpioSetuid != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&cpioSetgid != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&cpioSticky != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+func (c *CpioArchive) Next() (File, error) {
+	if len(c.queue) > 0 {
+		f := c.queue[0]
+		c.queue = c.queue[1:]
+		return f, nil
+	}
+	if c.eof {
+		return File{}, io.EOF
+	}
+
+	for {
+		// skip any unread data from the previous entry
+		if _, err := io.CopyN(io.Discard, c.r, c.data.n+c.pad); err != nil {
+			return File{}, err
+		}
+		c.data.n, c.pad = 0, 0
+
+		var hdr [110]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return File{}, err
+		}
+		if magic := string(hdr[:6]); magic != "070701" && magic != "070702" {
+			return File{}, fmt.Errorf("cpio: unsupported format %q", magic)
+		}
+		// ino, mode, uid, gid, nlink, mtime, filesize, devmajor, devminor,
+		// rdevmajor, rdevminor, namesize, check
+		var fields [13]uint32
+		for i := range fields {
+			v, err := strconv.ParseUint(string(hdr[6+8*i:14+8*i]), 16, 32)
+			if err != nil {
+				return File{}, fmt.Errorf("cpio: invalid header: %w", err)
+			}
+			fields[i] = uint32(v)
+		}
+		ino, mode, nlink, size, namesize := fields[0], fields[1], fields[4], int64(fields[6]), int64(fields[11])
+
+		// the name is padded so that the header and name are a multiple of 4
+		name := make([]byte, namesize+(4-(110+namesize)%4)%4)
+		if _, err := io.ReadFull(c.r, name); err != nil {
+			return File{}, err
+		}
+		fname := string(bytes.TrimRight(name[:namesize], "\x00"))
+		if fname == "TRAILER!!!" {
+			c.eof = true
+			c.flushLinks()
+			return c.Next()
+		}
+		c.data = entryReader{r: c.r, n: size}
+		c.pad = (4 - size%4) % 4
+
+		fname = strings.TrimPrefix(fname, "./")
+		if fname == "" || fname == "." {
+			continue
+		}
+
+		f := File{
+			Name: fname,
+			Mode: cpioMode(mode),
+		}
+		switch mode & cpioTypeMask {
+		case cpioDir:
+			// directories end with a slash, as in tar and zip archives
+			f.Name += "/"
+			f.Type = TypeDir
+		case cpioSymlink:
+			f.Type = TypeSymlink
+			target, err := io.ReadAll(&c.data)
+			if err != nil {
+				return File{}, err
+			}
+			f.LinkName = string(target)
+		case cpioReg:
+			f.Type = TypeNormal
+			if nlink > 1 {
+				// the data for a set of hard links is stored with the last
+				// one, and the others are empty. The last one is also empty
+				// if the file is, so the set is complete once all of its
+				// links have been found.
+				key := [3]uint32{ino, fields[7], fields[8]}
+				set := c.links[key]
+				if set == nil {
+					set = &cpioLinks{mode: f.Mode}
+					c.links[key] = set
+					c.order = append(c.order, key)
+				}
+				if size == 0 && uint32(len(set.names))+1 < nlink {
+					set.names = append(set.names, fname)
+					continue
+				}
+				c.queueLinks(set.names, fname, f.Mode)
+				delete(c.links, key)
+			}
+		case cpioChar, cpioBlock, cpioFifo:
+			f.Type = TypeDevice
+		default:
+			f.Type = TypeOther
+		}
+		return f, nil
+	}
+}
+
+// queueLinks queues hard links named 'names' to the file 'target'.
+func (c *CpioArchive) queueLinks(names []string, target string, mode fs.FileMode) {
+	for _, l := range names {
+		c.queue = append(c.queue, File{
+			Name:     l,
+			LinkName: target,
+			Mode:     mode,
+			Type:     TypeLink,
+		})
+	}
+}
+
+// flushLinks queues the sets of hard links that were not completed before
+// the end of the archive, which happens when some of the links are not in
+// it. None of their entries had data, so the first of each set is an empty
+// file and the rest link to it.
+func (c *CpioArchive) flushLinks() {
+	for _, key := range c.order {
+		set, ok := c.links[key]
+		if !ok {
+			continue
+		}
+		c.queue = append(c.queue, File{
+			Name: set.names[0],
+			Mode: set.mode,
+			Type: TypeNormal,
+		})
+		c.queueLinks(set.names[1:], set.names[0], set.mode)
+		delete(c.links, key)
+	}
+	c.order = nil
+}
+
+func (c *CpioArchive) Open() (io.Reader, error) {
+	return &c.data, nil
+}
+
+// rpmHeader parses the RPM header structure at offset 'pos' in src, and
+// returns its size along with the values of its string tags.
+func rpmHeader(src Source, pos int64) (int64, map[uint32]string, error) {
+	intro := make([]byte, 16)
+	if _, err := src.ReadAt(intro, pos); err != nil || !bytes.Equal(intro[:4], []byte{0x8e, 0xad, 0xe8, 0x01}) {
+		return 0, nil, errors.New("invalid header magic")
+	}
+	nindex := int64(binary.BigEndian.Uint32(intro[8:12]))
+	hsize := int64(binary.BigEndian.Uint32(intro[12:16]))
+	size := 16 + 16*nindex + hsize
+	if size > src.Size()-pos {
+		return 0, nil, errors.New("header is truncated")
+	}
+	data := make([]byte, size)
+	if _, err := src.ReadAt(data, pos); err != nil {
+		return 0, nil, err
+	}
+
+	const stringType = 6
+
+	store := data[16+16*nindex : size]
+	strs := make(map[uint32]string)
+	for i := int64(0); i < nindex; i++ {
+		entry := data[16+16*i : 32+16*i]
+		tag := binary.BigEndian.Uint32(entry[0:4])
+		typ := binary.BigEndian.Uint32(entry[4:8])
+		off := int(binary.BigEndian.Uint32(entry[8:12]))
+		if typ != stringType || off < 0 || off >= len(store) {
+			continue
+		}
+		if end := bytes.IndexByte(store[off:], 0); end >= 0 {
+			strs[tag] = string(store[off : off+end])
+		}
+	}
+	return size, strs, nil
+}
+
+// NewRpmArchive reads the files in an RPM package. The package's payload is a
+// compressed cpio archive, and the compression is determined by the package
+// header so the given decompressor is not used.
+func NewRpmArchive(src Source, d DecompFn) (Archive, error) {
+	const leadsize = 96
+	lead := make([]byte, leadsize)
+	if _, err := src.ReadAt(lead, 0); err != nil || !bytes.Equal(lead[:4], []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return nil, errors.New("rpm: invalid lead magic")
+	}
+
+	off := int64(leadsize)
+	size, _, err := rpmHeader(src, off)
+	if err != nil {
+		return nil, fmt.Errorf("rpm: signature: %w", err)
+	}
+	// the signature is padded to a multiple of 8 bytes
+	off += size + (8-size%8)%8
+	if off > src.Size() {
+		return nil, errors.New("rpm: signature is truncated")
+	}
+
+	const (
+		tagPayloadFormat     = 1124
+		tagPayloadCompressor = 1125
+	)
+
+	size, tags, err := rpmHeader(src, off)
+	if err != nil {
+		return nil, fmt.Errorf("rpm: header: %w", err)
+	}
+	off += size
+
+	if format, ok := tags[tagPayloadFormat]; ok && format != "cpio" {
+		return nil, fmt.Errorf("rpm: unsupported payload format %s", format)
+	}
+
+	var decomp DecompFn
+	switch comp := tags[tagPayloadCompressor]; comp {
+	case "gzip", "":
+		decomp = gunzipper
+	case "xz":
+		decomp = xunzipper
+	case "lzma":
+		decomp = lzmaunzipper
+	case "zstd":
+		decomp = zstdunzipper
+	case "bzip2":
+		decomp = b2unzipper
+	default:
+		return nil, fmt.Errorf("rpm: unsupported payload compressor %s", comp)
+	}
+
+	ar, err := NewCpioArchive(io.NewSectionReader(src, off, src.Size()-off), decomp)
+	if err != nil {
+		return nil, fmt.Errorf("rpm: %w", err)
+	}
+	return ar, nil
 }
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"testing"
+)
+
+// tarEntry is a file to store in a test archive.
+type tarEntry struct {
+	name string
+	link string
+	typ  byte
+	mode int64
+	data string
+}
+
+func makeTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Linkname: e.link,
+			Typeflag: e.typ,
+			Mode:     e.mode,
+			Size:     int64(len(e.data)),
+		}
+		if e.typ != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeGzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeAr returns an ar archive with the members in 'names' and 'data'.
+func makeAr(names []string, data [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	for i, name := range names {
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name+"/", 0, 0, 0, 0644, len(data[i]))
+		buf.Write(data[i])
+		if len(data[i])%2 != 0 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// readArchive returns the files in 'ar' and the contents of the regular ones.
+func readArchive(t *testing.T, ar Archive) ([]File, map[string]string) {
+	t.Helper()
+	var files []File
+	contents := make(map[string]string)
+	for {
+		f, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+		if f.Type == TypeNormal {
+			r, err := ar.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents[f.Name] = string(b)
+		}
+	}
+	return files, contents
+}
+
+func makeDeb(t *testing.T, member string, data []byte) []byte {
+	return makeAr(
+		[]string{"debian-binary", "control.tar.gz", member},
+		[][]byte{[]byte("2.0\n"), makeGzip(t, makeTar(t, nil)), data},
+	)
+}
+
+func TestDebArchive(t *testing.T) {
+	data := makeTar(t, []tarEntry{
+		{name: "./", typ: tar.TypeDir, mode: 0755},
+		{name: "./usr/bin/", typ: tar.TypeDir, mode: 0755},
+		{name: "./usr/bin/tool", typ: tar.TypeReg, mode: 0755, data: "binary"},
+		{name: "./usr/bin/alias", typ: tar.TypeLink, link: "./usr/bin/tool"},
+		{name: "./usr/bin/short", typ: tar.TypeSymlink, link: "tool"},
+	})
+
+	for _, tt := range []struct {
+		member string
+		data   []byte
+	}{
+		{"data.tar", data},
+		{"data.tar.gz", makeGzip(t, data)},
+	} {
+		t.Run(tt.member, func(t *testing.T) {
+			ar, err := NewDebArchive(bytes.NewReader(makeDeb(t, tt.member, tt.data)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, contents := readArchive(t, ar)
+			want := []File{
+				{Name: "usr/bin/", Mode: 0755, Type: TypeDir},
+				{Name: "usr/bin/tool", Mode: 0755, Type: TypeNormal},
+				{Name: "usr/bin/alias", LinkName: "usr/bin/tool", Type: TypeLink},
+				{Name: "usr/bin/short", LinkName: "tool", Type: TypeSymlink},
+			}
+			if len(files) != len(want) {
+				t.Fatalf("files = %v, want %v", files, want)
+			}
+			for i := range want {
+				if files[i] != want[i] {
+					t.Errorf("file %d = %+v, want %+v", i, files[i], want[i])
+				}
+			}
+			if contents["usr/bin/tool"] != "binary" {
+				t.Errorf("contents = %q", contents["usr/bin/tool"])
+			}
+		})
+	}
+}
+
+func TestDebArchiveInvalid(t *testing.T) {
+	valid := makeDeb(t, "data.tar", makeTar(t, nil))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not ar", []byte("PK\x03\x04 not a deb")},
+		{"no data member", makeAr([]string{"debian-binary"}, [][]byte{[]byte("2.0\n")})},
+		{"unsupported member", makeDeb(t, "data.tar.lz", nil)},
+		{"truncated", valid[:len(valid)-100]},
+		{"bad header", append([]byte("!<arch>\n"), bytes.Repeat([]byte{'x'}, 60)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDebArchive(bytes.NewReader(tt.data), nil); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestArMemberAlignment(t *testing.T) {
+	// members with an odd size are padded to keep the next one aligned
+	src := makeAr([]string{"odd", "data.tar"}, [][]byte{[]byte("abc"), []byte("found")})
+	r, name, err := arMember(bytes.NewReader(src), "data.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	if name != "data.tar" || string(b) != "found" {
+		t.Errorf("member = %s %q", name, b)
+	}
+	if _, _, err := arMember(bytes.NewReader(src), "control"); err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("missing member: err = %v", err)
+	}
+}
+
+// cpioEntry is a file to store in a test cpio archive.
+type cpioEntry struct {
+	name  string
+	ino   uint32
+	mode  uint32
+	nlink uint32
+	data  string
+}
+
+// makeCpio returns a cpio archive in the 'newc' format.
+func makeCpio(entries []cpioEntry) []byte {
+	var buf bytes.Buffer
+	pad := func() {
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	write := func(e cpioEntry) {
+		fmt.Fprintf(&buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			e.ino, e.mode, 0, 0, e.nlink, 0, len(e.data), 0, 0, 0, 0, len(e.name)+1, 0)
+		buf.WriteString(e.name)
+		buf.WriteByte(0)
+		pad()
+		buf.WriteString(e.data)
+		pad()
+	}
+	for _, e := range entries {
+		write(e)
+	}
+	write(cpioEntry{name: "TRAILER!!!", nlink: 1})
+	return buf.Bytes()
+}
+
+func TestCpioArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []cpioEntry
+		want    []File
+		data    map[string]string
+	}{
+		{
+			name: "files",
+			entries: []cpioEntry{
+				{name: ".", mode: cpioDir | 0755, nlink: 2},
+				{name: "./usr/bin", ino: 1, mode: cpioDir | 0755, nlink: 2},
+				{name: "./usr/bin/tool", ino: 2, mode: cpioReg | 0755, nlink: 1, data: "binary"},
+				{name: "./usr/bin/short", ino: 3, mode: cpioSymlink | 0777, nlink: 1, data: "tool"},
+				{name: "./usr/bin/su", ino: 4, mode: cpioReg | cpioSetuid | 0755, nlink: 1, data: "x"},
+				{name: "./dev/null", ino: 5, mode: cpioChar | 0666, nlink: 1},
+			},
+			want: []File{
+				{Name: "usr/bin/", Mode: 0755, Type: TypeDir},
+				{Name: "usr/bin/tool", Mode: 0755, Type: TypeNormal},
+				{Name: "usr/bin/short", LinkName: "tool", Mode: 0777, Type: TypeSymlink},
+				{Name: "usr/bin/su", Mode: 0755 | fs.ModeSetuid, Type: TypeNormal},
+				{Name: "dev/null", Mode: 0666, Type: TypeDevice},
+			},
+			data: map[string]string{"usr/bin/tool": "binary", "usr/bin/su": "x"},
+		},
+		{
+			name: "hard links",
+			entries: []cpioEntry{
+				{name: "a", ino: 7, mode: cpioReg | 0755, nlink: 3},
+				{name: "b", ino: 7, mode: cpioReg | 0755, nlink: 3},
+				{name: "c", ino: 7, mode: cpioReg | 0755, nlink: 3, data: "shared"},
+				{name: "d", ino: 8, mode: cpioReg | 0644, nlink: 1, data: "other"},
+			},
+			want: []File{
+				{Name: "c", Mode: 0755, Type: TypeNormal},
+				{Name: "a", LinkName: "c", Mode: 0755, Type: TypeLink},
+				{Name: "b", LinkName: "c", Mode: 0755, Type: TypeLink},
+				{Name: "d", Mode: 0644, Type: TypeNormal},
+			},
+			data: map[string]string{"c": "shared", "d": "other"},
+		},
+		{
+			name: "empty hard links",
+			entries: []cpioEntry{
+				{name: "a", ino: 7, mode: cpioReg | 0644, nlink: 2},
+				{name: "b", ino: 7, mode: cpioReg | 0644, nlink: 2},
+				{name: "d", ino: 8, mode: cpioReg | 0644, nlink: 1, data: "other"},
+			},
+			want: []File{
+				{Name: "b", Mode: 0644, Type: TypeNormal},
+				{Name: "a", LinkName: "b", Mode: 0644, Type: TypeLink},
+				{Name: "d", Mode: 0644, Type: TypeNormal},
+			},
+			data: map[string]string{"b": "", "d": "other"},
+		},
+		{
+			name: "incomplete hard links",
+			entries: []cpioEntry{
+				{name: "a", ino: 7, mode: cpioReg | 0644, nlink: 3},
+				{name: "b", ino: 7, mode: cpioReg | 0644, nlink: 3},
+			},
+			want: []File{
+				{Name: "a", Mode: 0644, Type: TypeNormal},
+				{Name: "b", LinkName: "a", Mode: 0644, Type: TypeLink},
+			},
+			data: map[string]string{"a": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar, err := NewCpioArchive(bytes.NewReader(makeCpio(tt.entries)), nounzipper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, contents := readArchive(t, ar)
+			if len(files) != len(tt.want) {
+				t.Fatalf("files = %+v, want %+v", files, tt.want)
+			}
+			for i := range tt.want {
+				if files[i] != tt.want[i] {
+					t.Errorf("file %d = %+v, want %+v", i, files[i], tt.want[i])
+				}
+			}
+			for name, data := range tt.data {
+				if got, ok := contents[name]; !ok || got != data {
+					t.Errorf("contents of %s = %q, want %q", name, got, data)
+				}
+			}
+		})
+	}
+}
+
+func TestCpioArchiveInvalid(t *testing.T) {
+	valid := makeCpio([]cpioEntry{{name: "tool", mode: cpioReg | 0755, nlink: 1, data: "binary"}})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"old format", append([]byte("070707"), valid[6:]...)},
+		{"bad field", append([]byte("070701zzzzzzzz"), valid[14:]...)},
+		{"truncated header", valid[:50]},
+		{"truncated data", valid[:115]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar, err := NewCpioArchive(bytes.NewReader(tt.data), nounzipper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				_, err = ar.Next()
+				if err == nil {
+					r, _ := ar.Open()
+					_, err = io.ReadAll(r)
+				}
+				if err != nil {
+					break
+				}
+			}
+			if err == io.EOF {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// rpmHeaderData returns an RPM header structure with string tags.
+func rpmHeaderData(tags map[uint32]string) []byte {
+	var index, store bytes.Buffer
+	keys := make([]int, 0, len(tags))
+	for tag := range tags {
+		keys = append(keys, int(tag))
+	}
+	sort.Ints(keys)
+	for _, tag := range keys {
+		binary.Write(&index, binary.BigEndian, []uint32{uint32(tag), 6, uint32(store.Len()), 1})
+		store.WriteString(tags[uint32(tag)])
+		store.WriteByte(0)
+	}
+	var buf bytes.Buffer
+	buf.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&buf, binary.BigEndian, []uint32{uint32(len(tags)), uint32(store.Len())})
+	buf.Write(index.Bytes())
+	buf.Write(store.Bytes())
+	return buf.Bytes()
+}
+
+func makeRpm(tags map[uint32]string, payload []byte) []byte {
+	var buf bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb})
+	buf.Write(lead)
+	buf.Write(rpmHeaderData(map[uint32]string{1000: "signature"}))
+	for buf.Len()%8 != 0 {
+		buf.WriteByte(0)
+	}
+	buf.Write(rpmHeaderData(tags))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func TestRpmArchive(t *testing.T) {
+	payload := makeCpio([]cpioEntry{
+		{name: "./usr/bin/tool", ino: 1, mode: cpioReg | 0755, nlink: 1, data: "binary"},
+	})
+	tests := []struct {
+		name    string
+		tags    map[uint32]string
+		payload []byte
+		err     bool
+	}{
+		{"gzip", map[uint32]string{1124: "cpio", 1125: "gzip"}, makeGzip(t, payload), false},
+		{"default compressor", map[uint32]string{1124: "cpio"}, makeGzip(t, payload), false},
+		{"unsupported compressor", map[uint32]string{1125: "lzip"}, payload, true},
+		{"unsupported format", map[uint32]string{1124: "drpm"}, payload, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar, err := NewRpmArchive(bytes.NewReader(makeRpm(tt.tags, tt.payload)), nil)
+			if tt.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, contents := readArchive(t, ar)
+			if len(files) != 1 || files[0].Name != "usr/bin/tool" || contents["usr/bin/tool"] != "binary" {
+				t.Errorf("files = %+v, contents = %q", files, contents)
+			}
+		})
+	}
+}
+
+func TestRpmArchiveInvalid(t *testing.T) {
+	valid := makeRpm(map[uint32]string{1125: "gzip"}, nil)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"bad lead", append([]byte("RPM!"), valid[4:]...)},
+		{"truncated lead", valid[:50]},
+		{"truncated signature", valid[:110]},
+		{"bad header magic", append(valid[:96:96], make([]byte, 64)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRpmArchive(bytes.NewReader(tt.data), nil); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
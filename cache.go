@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// GetCacheDir returns the directory where eget caches downloads. It is
+// $EGET_CACHE if set, or 'eget' in the OS's user cache directory
+// ($XDG_CACHE_HOME on Linux).
+func GetCacheDir() (string, error) {
+	if dir := os.Getenv("EGET_CACHE"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "eget"), nil
+}
+
+// A CacheEntry records a downloaded asset. The data is stored in a blob named
+// by its SHA-256 checksum, so assets with the same data are only stored once.
+// The ETag and Last-Modified time of the response are kept so that the entry
+// can be revalidated, since the data at a URL may change.
+type CacheEntry struct {
+	SHA256 string    `json:"sha256"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"` // when it was downloaded
+	Used   time.Time `json:"used"` // when it was last used
+
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validated returns true if the entry can be revalidated with a conditional
+// request.
+func (e *CacheEntry) validated() bool {
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// A Cache stores downloaded assets, indexed by URL.
+type Cache struct {
+	Entries map[string]*CacheEntry `json:"entries"`
+
+	MaxSize int64         `json:"-"` // total size of the blobs to keep
+	MaxAge  time.Duration `json:"-"` // how long to keep unused entries
+
+	dir string
+}
+
+// OpenCache loads the index of the cache. A missing cache is treated as
+// empty.
+func OpenCache() (*Cache, error) {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		Entries: make(map[string]*CacheEntry),
+		MaxSize: opts.CacheLimit,
+		MaxAge:  opts.CacheMaxAge,
+		dir:     dir,
+	}
+	data, err := os.ReadFile(c.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.indexPath(), err)
+	}
+	if c.Entries == nil {
+		c.Entries = make(map[string]*CacheEntry)
+	}
+	return c, nil
+}
+
+func (c *Cache) indexPath() string {
+	return filepath.Join(c.dir, "index.json")
+}
+
+func (c *Cache) blobPath(sum string) string {
+	return filepath.Join(c.dir, "blobs", sum)
+}
+
+// save writes the index, replacing the previous one atomically.
+func (c *Cache) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.indexPath())
+}
+
+// Open returns the cached data of 'url', or nil if it is not cached. The
+// data is checked against its checksum, and dropped from the cache if it does
+// not match.
+func (c *Cache) Open(url string) (*os.File, *CacheEntry, error) {
+	e, ok := c.Entries[url]
+	if !ok {
+		return nil, nil, nil
+	}
+	f, err := os.Open(c.blobPath(e.SHA256))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, c.Remove(url)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if fmt.Sprintf("%x", h.Sum(nil)) != e.SHA256 {
+		f.Close()
+		return nil, nil, c.Remove(url)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	e.Used = time.Now()
+	return f, e, c.save()
+}
+
+// A cacheWriter stores data being downloaded in the cache.
+type cacheWriter struct {
+	f     *os.File
+	h     hash.Hash
+	n     int64
+	cache *Cache
+}
+
+// Create returns a writer for storing the data of a download. The data is
+// only added to the cache once it is committed.
+func (c *Cache) Create() (*cacheWriter, error) {
+	dir := filepath.Join(c.dir, "blobs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return nil, err
+	}
+	atExit(func() { os.Remove(f.Name()) })
+	return &cacheWriter{
+		f:     f,
+		h:     sha256.New(),
+		cache: c,
+	}, nil
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	w.h.Write(b)
+	n, err := w.f.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// Abort discards the data that was written.
+func (w *cacheWriter) Abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// Commit adds the data that was written to the cache as the data of 'url',
+// with the validators of the response it was downloaded from, and evicts old
+// entries if the cache is too large.
+func (w *cacheWriter) Commit(url, etag, modified string) error {
+	if err := w.f.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+	sum := fmt.Sprintf("%x", w.h.Sum(nil))
+	// the data of a URL that changed replaces its previous data
+	w.cache.remove(url)
+	if err := os.Rename(w.f.Name(), w.cache.blobPath(sum)); err != nil {
+		w.Abort()
+		return err
+	}
+	now := time.Now()
+	w.cache.Entries[url] = &CacheEntry{
+		SHA256: sum,
+		Size:   w.n,
+		Time:   now,
+		Used:   now,
+
+		ETag:         etag,
+		LastModified: modified,
+	}
+	w.cache.evict()
+	return w.cache.save()
+}
+
+// Remove drops the entry of 'url', and its blob if no other entry uses it.
+func (c *Cache) Remove(url string) error {
+	c.remove(url)
+	return c.save()
+}
+
+func (c *Cache) remove(url string) {
+	e, ok := c.Entries[url]
+	if !ok {
+		return
+	}
+	delete(c.Entries, url)
+	for _, other := range c.Entries {
+		if other.SHA256 == e.SHA256 {
+			return
+		}
+	}
+	os.Remove(c.blobPath(e.SHA256))
+}
+
+// blobs returns the total size of the blobs in the cache.
+func (c *Cache) blobs() (count int, size int64) {
+	seen := make(map[string]bool)
+	for _, e := range c.Entries {
+		if !seen[e.SHA256] {
+			seen[e.SHA256] = true
+			count++
+			size += e.Size
+		}
+	}
+	return count, size
+}
+
+// evict removes the entries that have not been used within MaxAge, and then
+// the least recently used entries until the blobs fit within MaxSize.
+func (c *Cache) evict() {
+	urls := make([]string, 0, len(c.Entries))
+	for url := range c.Entries {
+		urls = append(urls, url)
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		return c.Entries[urls[i]].Used.Before(c.Entries[urls[j]].Used)
+	})
+	for _, url := range urls {
+		_, size := c.blobs()
+		old := c.MaxAge > 0 && time.Since(c.Entries[url].Used) > c.MaxAge
+		large := c.MaxSize > 0 && size > c.MaxSize
+		if !old && !large {
+			continue
+		}
+		c.remove(url)
+	}
+}
+
+// Clean removes all downloads from the cache, and returns the number of blobs
+// and bytes that were removed. The cached API responses and partial downloads
+// in the same directory are kept.
+func (c *Cache) Clean() (int, int64, error) {
+	count, size := c.blobs()
+	if err := os.RemoveAll(filepath.Join(c.dir, "blobs")); err != nil {
+		return 0, 0, err
+	}
+	if err := os.Remove(c.indexPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, 0, err
+	}
+	c.Entries = make(map[string]*CacheEntry)
+	return count, size, nil
+}
+
+// Stats describes the contents of the cache.
+func (c *Cache) Stats() string {
+	count, size := c.blobs()
+	var oldest, newest time.Time
+	for _, e := range c.Entries {
+		if oldest.IsZero() || e.Time.Before(oldest) {
+			oldest = e.Time
+		}
+		if e.Time.After(newest) {
+			newest = e.Time
+		}
+	}
+	s := fmt.Sprintf("Cache: %s\nURLs: %d\nFiles: %d (%s)", c.dir, len(c.Entries), count, formatBytes(size))
+	if count > 0 {
+		s += fmt.Sprintf("\nOldest download: %s\nNewest download: %s", oldest.Format(time.RFC1123), newest.Format(time.RFC1123))
+	}
+	if c.MaxSize > 0 {
+		s += fmt.Sprintf("\nMaximum size: %s", formatBytes(c.MaxSize))
+	}
+	if c.MaxAge > 0 {
+		s += fmt.Sprintf("\nMaximum age: %d days", int(c.MaxAge.Hours()/24))
+	}
+	return s
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
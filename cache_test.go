@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// setOpts replaces the options for the duration of a test.
+func setOpts(t *testing.T, o Flags) {
+	t.Helper()
+	saved := opts
+	opts = o
+	opts.Quiet = true
+	t.Cleanup(func() { opts = saved })
+}
+
+// download downloads 'url' and returns its data.
+func download(t *testing.T, url string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Download(url, &buf, progressBar); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// assetServer serves 'data' with an ETag, and counts the requests that
+// returned the data and that were not modified.
+type assetServer struct {
+	sync.Mutex
+	data   string
+	etag   bool
+	full   int
+	notMod int
+}
+
+func (s *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if s.etag {
+		etag := fmt.Sprintf("%q", fmt.Sprintf("%x", len(s.data)))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			s.notMod++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	s.full++
+	w.Write([]byte(s.data))
+}
+
+func TestDownloadCache(t *testing.T) {
+	setOpts(t, Flags{})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	s := &assetServer{data: "v1", etag: true}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	url := srv.URL + "/tool.tar.gz"
+
+	if got := download(t, url); got != "v1" {
+		t.Fatalf("first download = %q", got)
+	}
+	// the cached data is revalidated rather than downloaded again
+	if got := download(t, url); got != "v1" {
+		t.Errorf("cached download = %q", got)
+	}
+	if s.full != 1 || s.notMod != 1 {
+		t.Errorf("%d downloads and %d revalidations, want 1 and 1", s.full, s.notMod)
+	}
+
+	// data that changed at the same URL is downloaded again, and replaces the
+	// cached data
+	s.data = "version 2"
+	if got := download(t, url); got != "version 2" {
+		t.Errorf("changed download = %q", got)
+	}
+	cache, err := OpenCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := cache.blobs(); count != 1 || len(cache.Entries) != 1 {
+		t.Errorf("%d blobs and %d entries after a change, want 1 and 1", count, len(cache.Entries))
+	}
+	blobs, _ := os.ReadDir(filepath.Join(cache.dir, "blobs"))
+	if len(blobs) != 1 {
+		t.Errorf("%d blob files, want 1", len(blobs))
+	}
+
+	// the cached data is used if the server can't be reached
+	srv.Close()
+	if got := download(t, url); got != "version 2" {
+		t.Errorf("download from an unavailable server = %q", got)
+	}
+}
+
+func TestDownloadCacheNoValidator(t *testing.T) {
+	setOpts(t, Flags{})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	s := &assetServer{data: "v1"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	url := srv.URL + "/tool"
+
+	download(t, url)
+	s.data = "v2"
+	// an entry without a validator can't be revalidated, so it is downloaded
+	// again
+	if got := download(t, url); got != "v2" {
+		t.Errorf("download = %q, want the changed data", got)
+	}
+	if s.full != 2 {
+		t.Errorf("%d downloads, want 2", s.full)
+	}
+}
+
+func TestCacheClean(t *testing.T) {
+	setOpts(t, Flags{})
+	dir := t.TempDir()
+	t.Setenv("EGET_CACHE", dir)
+	s := &assetServer{data: "data", etag: true}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	download(t, srv.URL+"/a")
+	download(t, srv.URL+"/b")
+
+	// other things stored in the cache directory
+	os.MkdirAll(filepath.Join(dir, "api"), 0755)
+	os.WriteFile(filepath.Join(dir, "api", "response.json"), []byte("{}"), 0644)
+	os.MkdirAll(filepath.Join(dir, "partial"), 0755)
+	os.WriteFile(filepath.Join(dir, "partial", "download.part"), []byte("da"), 0644)
+
+	cache, err := OpenCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, size, err := cache.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || size != 4 {
+		t.Errorf("removed %d blobs of %d bytes, want 1 of 4", count, size)
+	}
+	if exists(filepath.Join(dir, "blobs")) || exists(filepath.Join(dir, "index.json")) {
+		t.Error("downloads were left in the cache")
+	}
+	if !exists(filepath.Join(dir, "api", "response.json")) || !exists(filepath.Join(dir, "partial", "download.part")) {
+		t.Error("the API cache or partial downloads were removed")
+	}
+	if cache, err = OpenCache(); err != nil || len(cache.Entries) != 0 {
+		t.Errorf("cache after cleaning: %v, %v", cache, err)
+	}
+}
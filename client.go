@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// clients by whether SSL verification is disabled, which may be set for
+	// each repository
+	clients    = make(map[bool]*http.Client)
+	clientLock sync.Mutex
+)
+
+// httpClient returns the HTTP client that is used for all requests, so that
+// connections are reused. It is created on first use, after the options have
+// been set.
+func httpClient() *http.Client {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	if c, ok := clients[opts.DisableSSL]; ok {
+		return c
+	}
+	c := &http.Client{
+		Transport: &clientTransport{
+			base: &authTransport{
+				base: newTLSTransport(),
+			},
+			readTimeout: opts.ReadTimeout,
+		},
+		Timeout: opts.Timeout,
+	}
+	clients[opts.DisableSSL] = c
+	return c
+}
+
+// dialer returns the dialer for connections, which limits the time to
+// connect to opts.ConnectTimeout.
+func dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+// A clientTransport sets the User-Agent of requests, and fails requests that
+// receive no data for readTimeout, either while waiting for the response or
+// while reading its body.
+type clientTransport struct {
+	base        http.RoundTripper
+	readTimeout time.Duration
+}
+
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "eget/"+Version)
+	}
+	if t.readTimeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	s := &stallTimer{
+		timeout: t.readTimeout,
+		cancel:  cancel,
+	}
+	s.timer = time.AfterFunc(t.readTimeout, s.expire)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		s.stop()
+		return nil, s.wrap(err)
+	}
+	resp.Body = &stallBody{
+		ReadCloser: resp.Body,
+		stall:      s,
+	}
+	return resp, nil
+}
+
+// A stallTimer cancels a request when it expires, and is reset whenever data
+// is received.
+type stallTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+
+	lock    sync.Mutex
+	expired bool
+}
+
+func (s *stallTimer) expire() {
+	s.lock.Lock()
+	s.expired = true
+	s.lock.Unlock()
+	s.cancel()
+}
+
+func (s *stallTimer) stop() {
+	s.timer.Stop()
+	s.cancel()
+}
+
+// wrap replaces the error of a cancelled request with a timeout error.
+func (s *stallTimer) wrap(err error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.expired {
+		return fmt.Errorf("no data received for %s", s.timeout)
+	}
+	return err
+}
+
+type stallBody struct {
+	io.ReadCloser
+	stall *stallTimer
+}
+
+func (b *stallBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.stall.timer.Reset(b.stall.timeout)
+	}
+	if err != nil && err != io.EOF {
+		err = b.stall.wrap(err)
+	}
+	return n, err
+}
+
+func (b *stallBody) Close() error {
+	b.stall.stop()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stallServer sends 'chunks' of the body 'interval' apart, and then stops
+// sending data until the test ends. If 'headers' is false, it stops before
+// sending the response.
+func stallServer(t *testing.T, headers bool, chunks int, interval time.Duration) *httptest.Server {
+	t.Helper()
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if headers {
+			w.Header().Set("Content-Length", "1000")
+			for i := 0; i < chunks; i++ {
+				w.Write([]byte(strings.Repeat("x", 10)))
+				w.(http.Flusher).Flush()
+				time.Sleep(interval)
+			}
+		}
+		<-done
+	}))
+	t.Cleanup(func() {
+		close(done)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestReadTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	tests := []struct {
+		name    string
+		headers bool
+		chunks  int
+		read    int // the number of bytes read before the stall
+	}{
+		{"before the response", false, 0, 0},
+		{"in the body", true, 1, 10},
+		// data that keeps arriving resets the timer
+		{"after a slow body", true, 5, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setOpts(t, Flags{ReadTimeout: timeout})
+			newClients(t)
+			srv := stallServer(t, tt.headers, tt.chunks, timeout/2)
+
+			start := time.Now()
+			var read int
+			resp, err := Get(srv.URL)
+			if err == nil {
+				var b []byte
+				b, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+				read = len(b)
+			}
+			elapsed := time.Since(start)
+
+			if err == nil || !strings.Contains(err.Error(), "no data received for 200ms") {
+				t.Fatalf("err = %v, want the stall error", err)
+			}
+			if read != tt.read {
+				t.Errorf("read %d bytes, want %d", read, tt.read)
+			}
+			// the request fails one timeout after the last data
+			want := timeout
+			if tt.chunks > 0 {
+				want += time.Duration(tt.chunks-1) * timeout / 2
+			}
+			if elapsed < want || elapsed > want+time.Second {
+				t.Errorf("failed after %v, want about %v", elapsed, want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/jessevdk/go-flags"
@@ -13,16 +14,52 @@ import (
 )
 
 type ConfigGlobal struct {
-	All          bool   `toml:"all"`
-	DownloadOnly bool   `toml:"download_only"`
-	File         string `toml:"file"`
-	GithubToken  string `toml:"github_token"`
-	Quiet        bool   `toml:"quiet"`
-	ShowHash     bool   `toml:"show_hash"`
-	Source       bool   `toml:"download_source"`
-	System       string `toml:"system"`
-	Target       string `toml:"target"`
-	UpgradeOnly  bool   `toml:"upgrade_only"`
+	All          bool     `toml:"all"`
+	DownloadOnly bool     `toml:"download_only"`
+	File         string   `toml:"file"`
+	GithubToken  string   `toml:"github_token"`
+	Quiet        bool     `toml:"quiet"`
+	ShowHash     bool     `toml:"show_hash"`
+	Source       bool     `toml:"download_source"`
+	System       string   `toml:"system"`
+	Target       string   `toml:"target"`
+	UpgradeOnly  bool     `toml:"upgrade_only"`
+	Versioned    bool     `toml:"versioned"`
+	Channel      string   `toml:"update_channel"`
+	FullInstall  bool     `toml:"full_install"`
+	ManDir       string   `toml:"man_dir"`
+	BashDir      string   `toml:"bash_completion_dir"`
+	ZshDir       string   `toml:"zsh_completion_dir"`
+	FishDir      string   `toml:"fish_completion_dir"`
+	PreInstall   []string `toml:"pre_install"`
+	PostInstall  []string `toml:"post_install"`
+	HookFailure  string   `toml:"hook_failure"`
+	NoCache      bool     `toml:"no_cache"`
+	CacheMaxMB   *int64   `toml:"cache_max_mb"`
+	CacheMaxDays *int64   `toml:"cache_max_days"`
+	APICacheTTL  *int64   `toml:"api_cache_hours"`
+	Retries      *int     `toml:"retries"`
+	RateWait     *int64   `toml:"rate_limit_wait"`
+	Segments     *int     `toml:"segments"`
+	CACerts      []string `toml:"ca_certs"`
+	MinTLS       string   `toml:"min_tls_version"`
+	Proxy        string   `toml:"proxy"`
+	NoProxy      string   `toml:"no_proxy"`
+	ConnTimeout  *int64   `toml:"connect_timeout"`
+	ReadTimeout  *int64   `toml:"read_timeout"`
+	Timeout      *int64   `toml:"timeout"`
+	NoGraphQL    bool     `toml:"no_graphql"`
+
+	// [[global.rewrite]] tables, applied in order
+	Rewrite []ConfigRewrite `toml:"rewrite"`
+}
+
+// A ConfigRewrite is a rule that replaces URLs that start with 'prefix' or
+// match 'regex' with the mirrors in 'replace'.
+type ConfigRewrite struct {
+	Prefix  string   `toml:"prefix"`
+	Regex   string   `toml:"regex"`
+	Replace []string `toml:"replace"`
 }
 
 type ConfigRepository struct {
@@ -40,6 +77,12 @@ type ConfigRepository struct {
 	UpgradeOnly  bool     `toml:"upgrade_only"`
 	Verify       string   `toml:"verify_sha256"`
 	DisableSSL   bool     `toml:"disable_ssl"`
+	AllowSetuid  bool     `toml:"allow_setuid"`
+	Versioned    bool     `toml:"versioned"`
+	FullInstall  bool     `toml:"full_install"`
+	PreInstall   []string `toml:"pre_install"`
+	PostInstall  []string `toml:"post_install"`
+	HookFailure  string   `toml:"hook_failure"`
 }
 
 type Config struct {
@@ -47,10 +90,23 @@ type Config struct {
 		Keys     []string
 		MetaData *toml.MetaData
 	}
-	Global       ConfigGlobal `toml:"global"`
+	Global       ConfigGlobal          `toml:"global"`
+	Hosts        map[string]ConfigHost `toml:"hosts"`
 	Repositories map[string]ConfigRepository
 }
 
+// A ConfigHost is a [hosts."name"] table with the authentication for
+// requests to a host.
+type ConfigHost struct {
+	Username string            `toml:"username"`
+	Password string            `toml:"password"`
+	Token    string            `toml:"token"`
+	Headers  map[string]string `toml:"headers"`
+
+	ClientCert string `toml:"client_cert"`
+	ClientKey  string `toml:"client_key"`
+}
+
 func LoadConfigurationFile(path string) (Config, error) {
 	var conf Config
 	meta, err := toml.DecodeFile(path, &conf)
@@ -147,6 +203,7 @@ func InitializeConfig() (*Config, error) {
 	}
 
 	delete(config.Repositories, "global")
+	delete(config.Repositories, "hosts")
 
 	// set default global values
 	if !config.Meta.MetaData.IsDefined("global", "all") {
@@ -227,6 +284,16 @@ func SetGlobalOptionsFromConfig(config *Config, parser *flags.Parser, opts *Flag
 		os.Setenv("EGET_GITHUB_TOKEN", config.Global.GithubToken)
 	}
 
+	// the proxy environment variables take precedence over the configuration
+	if config.Global.Proxy != "" && os.Getenv("HTTPS_PROXY") == "" && os.Getenv("https_proxy") == "" &&
+		os.Getenv("HTTP_PROXY") == "" && os.Getenv("http_proxy") == "" {
+		os.Setenv("HTTPS_PROXY", config.Global.Proxy)
+		os.Setenv("HTTP_PROXY", config.Global.Proxy)
+	}
+	if config.Global.NoProxy != "" && os.Getenv("NO_PROXY") == "" && os.Getenv("no_proxy") == "" {
+		os.Setenv("NO_PROXY", config.Global.NoProxy)
+	}
+
 	opts.Tag = update("", cli.Tag)
 	opts.Prerelease = update(false, cli.Prerelease)
 	opts.Source = update(config.Global.Source, cli.Source)
@@ -246,7 +313,91 @@ func SetGlobalOptionsFromConfig(config *Config, parser *flags.Parser, opts *Flag
 	opts.Hash = update(config.Global.ShowHash, cli.Hash)
 	opts.Verify = update("", cli.Verify)
 	opts.Remove = update(false, cli.Remove)
+	opts.DryRun = update(false, cli.DryRun)
 	opts.DisableSSL = update(false, cli.DisableSSL)
+	opts.AllowSetuid = update(false, cli.AllowSetuid)
+	opts.Rollback = update(false, cli.Rollback)
+	opts.Versioned = update(config.Global.Versioned, cli.Versioned)
+	opts.ListVers = update(false, cli.ListVers)
+	opts.Use = update("", cli.Use)
+	opts.Prune = update(false, cli.Prune)
+	opts.Channel = update(config.Global.Channel, cli.Channel)
+	opts.FullInstall = update(config.Global.FullInstall, cli.FullInstall)
+	opts.PreInstall = config.Global.PreInstall
+	opts.PostInstall = config.Global.PostInstall
+	opts.HookFailure = config.Global.HookFailure
+	opts.NoHooks = update(false, cli.NoHooks)
+	opts.NoCache = update(config.Global.NoCache, cli.NoCache)
+	// a limit of 0 disables it
+	opts.CacheLimit = update(1024, config.Global.CacheMaxMB) << 20
+	opts.CacheMaxAge = time.Duration(update(30, config.Global.CacheMaxDays)) * 24 * time.Hour
+	opts.APICacheTTL = time.Duration(update(24, config.Global.APICacheTTL)) * time.Hour
+	opts.Retries = update(update(3, config.Global.Retries), cli.Retries)
+	if opts.Retries < 0 {
+		return fmt.Errorf("invalid number of retries %d", opts.Retries)
+	}
+	// the longest time to wait for the rate limit to reset; by default it
+	// is not waited for
+	opts.RateWait = time.Duration(update(0, config.Global.RateWait)) * time.Minute
+	opts.Segments = update(update(1, config.Global.Segments), cli.Segments)
+	if opts.Segments < 1 {
+		return fmt.Errorf("invalid number of segments %d", opts.Segments)
+	}
+	opts.Rewrites = nil
+	for _, rc := range config.Global.Rewrite {
+		r, err := NewRewrite(rc.Prefix, rc.Regex, rc.Replace)
+		if err != nil {
+			return err
+		}
+		opts.Rewrites = append(opts.Rewrites, r)
+	}
+	opts.Hosts = make(map[string]HostAuth)
+	for host, hc := range config.Hosts {
+		opts.Hosts[host], err = NewHostAuth(host, hc)
+		if err != nil {
+			return err
+		}
+	}
+	// a netrc file that can't be read is only needed for the hosts in it
+	opts.Netrc, err = loadNetrc()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: not using netrc:", err)
+	}
+	opts.RootCAs, err = loadCAs(append(config.Global.CACerts, update([]string{}, cli.CACerts)...))
+	if err != nil {
+		return err
+	}
+	opts.MinTLS, err = parseTLSVersion(update(config.Global.MinTLS, cli.MinTLS))
+	if err != nil {
+		return err
+	}
+	// timeouts are in seconds, and 0 disables them
+	opts.ConnectTimeout = time.Duration(update(30, config.Global.ConnTimeout)) * time.Second
+	opts.ReadTimeout = time.Duration(update(60, config.Global.ReadTimeout)) * time.Second
+	opts.Timeout = time.Duration(update(0, config.Global.Timeout)) * time.Second
+	opts.NoGraphQL = config.Global.NoGraphQL
+
+	opts.ExtraDirs, err = DefaultExtraDirs()
+	if err != nil {
+		return err
+	}
+	for _, d := range []struct {
+		dir  *string
+		conf string
+	}{
+		{&opts.ExtraDirs.Man, config.Global.ManDir},
+		{&opts.ExtraDirs.Bash, config.Global.BashDir},
+		{&opts.ExtraDirs.Zsh, config.Global.ZshDir},
+		{&opts.ExtraDirs.Fish, config.Global.FishDir},
+	} {
+		if d.conf == "" {
+			continue
+		}
+		*d.dir, err = home.Expand(d.conf)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -271,8 +422,29 @@ func SetProjectOptionsFromConfig(config *Config, parser *flags.Parser, opts *Fla
 			opts.UpgradeOnly = update(repo.UpgradeOnly, cli.UpgradeOnly)
 			opts.Verify = update(repo.Verify, cli.Verify)
 			opts.DisableSSL = update(repo.DisableSSL, cli.DisableSSL)
+			opts.AllowSetuid = update(repo.AllowSetuid, cli.AllowSetuid)
+			opts.Versioned = update(repo.Versioned, cli.Versioned)
+			opts.FullInstall = update(repo.FullInstall, cli.FullInstall)
+			// hooks of the repository replace the global ones if they are
+			// set, so an empty list disables them
+			if repo.PreInstall != nil {
+				opts.PreInstall = repo.PreInstall
+			}
+			if repo.PostInstall != nil {
+				opts.PostInstall = repo.PostInstall
+			}
+			if repo.HookFailure != "" {
+				opts.HookFailure = repo.HookFailure
+			}
 			break
 		}
 	}
+	switch opts.HookFailure {
+	case "":
+		opts.HookFailure = HookAbort
+	case HookAbort, HookWarn:
+	default:
+		return fmt.Errorf("invalid hook_failure %s (must be %s or %s)", opts.HookFailure, HookAbort, HookWarn)
+	}
 	return nil
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/cpu"
+)
+
+// parseAMD64Level parses an x86-64 microarchitecture level given in GOAMD64
+// notation (v1, v2, v3 or v4).
+func parseAMD64Level(s string) (int, bool) {
+	if !strings.HasPrefix(s, "v") {
+		return 0, false
+	}
+	l, err := strconv.Atoi(s[1:])
+	if err != nil || l < 1 || l > 4 {
+		return 0, false
+	}
+	return l, true
+}
+
+// splitAMD64Level splits an architecture such as 'amd64v3' or 'amd64/v3' into
+// the GOARCH value and the x86-64 microarchitecture level. The level is 0 if
+// none is given.
+func splitAMD64Level(arch string) (string, int) {
+	rest := strings.TrimPrefix(arch, "amd64")
+	if rest == arch {
+		return arch, 0
+	}
+	if l, ok := parseAMD64Level(strings.TrimPrefix(rest, "/")); ok {
+		return "amd64", l
+	}
+	return arch, 0
+}
+
+// HostAMD64Level returns the x86-64 microarchitecture level supported by the
+// host CPU, as determined from CPUID flags. GOAMD64 may lower the level, but
+// never raises it above what the CPU can run.
+func HostAMD64Level() int {
+	return limitAMD64Level(cpuAMD64Level(), os.Getenv("GOAMD64"))
+}
+
+// limitAMD64Level returns the lower of the CPU's level and the level in
+// 'goamd64', if it is valid.
+func limitAMD64Level(level int, goamd64 string) int {
+	if l, ok := parseAMD64Level(goamd64); ok && l < level {
+		return l
+	}
+	return level
+}
+
+// cpuAMD64Level returns the x86-64 microarchitecture level of the CPU, or 1
+// if the host is not x86-64.
+func cpuAMD64Level() int {
+	if runtime.GOARCH != "amd64" {
+		return 1
+	}
+
+	x := cpu.X86
+	// LAHF/SAHF, F16C, LZCNT and MOVBE are not reported by the cpu package,
+	// but every processor with the other features of a level has them.
+	v2 := x.HasCX16 && x.HasPOPCNT && x.HasSSE3 && x.HasSSE41 && x.HasSSE42 && x.HasSSSE3
+	v3 := v2 && x.HasAVX && x.HasAVX2 && x.HasBMI1 && x.HasBMI2 && x.HasFMA && x.HasOSXSAVE
+	v4 := v3 && x.HasAVX512F && x.HasAVX512BW && x.HasAVX512CD && x.HasAVX512DQ && x.HasAVX512VL
+
+	switch {
+	case v4:
+		return 4
+	case v3:
+		return 3
+	case v2:
+		return 2
+	}
+	return 1
+}
+
+var amd64lvlrgx = regexp.MustCompile(`(?i)(x64|amd64|x86[-_]?64)[-_]?v([1-4])([^0-9]|$)`)
+
+// amd64Level returns the x86-64 microarchitecture level that the asset is
+// built for, or 1 if the asset name does not specify one.
+func amd64Level(s string) int {
+	m := amd64lvlrgx.FindStringSubmatch(s)
+	if m == nil {
+		return 1
+	}
+	l, _ := strconv.Atoi(m[2])
+	return l
+}
+
+// bestAMD64Level returns the assets built for the highest x86-64
+// microarchitecture level found among them.
+func bestAMD64Level(assets []string) []string {
+	best := 0
+	var bests []string
+	for _, a := range assets {
+		l := amd64Level(a)
+		if l > best {
+			best = l
+			bests = bests[:0]
+		}
+		if l == best {
+			bests = append(bests, a)
+		}
+	}
+	return bests
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLimitAMD64Level(t *testing.T) {
+	tests := []struct {
+		level   int
+		goamd64 string
+		want    int
+	}{
+		{2, "", 2},
+		{2, "v4", 2},
+		{4, "v2", 2},
+		{3, "v3", 3},
+		{3, "v9", 3},
+		{3, "garbage", 3},
+	}
+	for _, tt := range tests {
+		if got := limitAMD64Level(tt.level, tt.goamd64); got != tt.want {
+			t.Errorf("limitAMD64Level(%d, %q) = %d, want %d", tt.level, tt.goamd64, got, tt.want)
+		}
+	}
+}
+
+func TestSplitAMD64Level(t *testing.T) {
+	tests := []struct {
+		arch  string
+		want  string
+		level int
+	}{
+		{"amd64", "amd64", 0},
+		{"amd64v3", "amd64", 3},
+		{"amd64/v2", "amd64", 2},
+		{"amd64v5", "amd64v5", 0},
+		{"arm64", "arm64", 0},
+	}
+	for _, tt := range tests {
+		arch, level := splitAMD64Level(tt.arch)
+		if arch != tt.want || level != tt.level {
+			t.Errorf("splitAMD64Level(%q) = %q, %d, want %q, %d", tt.arch, arch, level, tt.want, tt.level)
+		}
+	}
+}
+
+func TestAMD64Level(t *testing.T) {
+	tests := []struct {
+		asset string
+		level int
+	}{
+		{"tool-linux-amd64.tar.gz", 1},
+		{"tool-linux-amd64v3.tar.gz", 3},
+		{"tool-linux-x86_64_v2.tar.gz", 2},
+		{"tool-linux-x86-64-v4.tar.gz", 4},
+		{"tool-linux-amd64-v12.tar.gz", 1},
+	}
+	for _, tt := range tests {
+		if got := amd64Level(tt.asset); got != tt.level {
+			t.Errorf("amd64Level(%q) = %d, want %d", tt.asset, got, tt.level)
+		}
+	}
+}
+
+func TestDetectAMD64Level(t *testing.T) {
+	assets := []string{
+		"tool-linux-amd64.tar.gz",
+		"tool-linux-amd64v2.tar.gz",
+		"tool-linux-amd64v3.tar.gz",
+		"tool-linux-amd64v4.tar.gz",
+		"tool-linux-arm64.tar.gz",
+	}
+	tests := []struct {
+		level  int
+		assets []string
+		want   string
+	}{
+		{1, assets, "tool-linux-amd64.tar.gz"},
+		{2, assets, "tool-linux-amd64v2.tar.gz"},
+		{3, assets, "tool-linux-amd64v3.tar.gz"},
+		{4, assets, "tool-linux-amd64v4.tar.gz"},
+		// levels that were not published fall back to the highest lower one
+		{4, assets[:3], "tool-linux-amd64v3.tar.gz"},
+		{3, []string{"tool-linux-amd64.tar.gz", "tool-linux-amd64v4.tar.gz"}, "tool-linux-amd64.tar.gz"},
+		// a plain asset is built for the baseline level
+		{4, []string{"tool-linux-amd64.tar.gz", "tool-linux-arm64.tar.gz"}, "tool-linux-amd64.tar.gz"},
+	}
+	for _, tt := range tests {
+		d, err := NewSystemDetector("linux", fmt.Sprintf("amd64v%d", tt.level))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Level != tt.level {
+			t.Fatalf("level = %d, want %d", d.Level, tt.level)
+		}
+		got, candidates, err := d.Detect(tt.assets)
+		if err != nil {
+			t.Errorf("v%d %v: %v (candidates %v)", tt.level, tt.assets, err, candidates)
+		} else if got != tt.want {
+			t.Errorf("v%d %v = %q, want %q", tt.level, tt.assets, got, tt.want)
+		}
+	}
+
+	// a CPU below the level of every asset has no match
+	d := &SystemDetector{Os: OSLinux, Arch: ArchAMD64, Level: 2}
+	if got, _, err := d.Detect([]string{"tool-linux-amd64v3.tar.gz", "tool-linux-amd64v4.tar.gz"}); err == nil {
+		t.Errorf("v2 selected %q", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"path"
 	"regexp"
+	"runtime"
 	"strings"
 )
 
@@ -154,6 +155,12 @@ var (
 		name:  "riscv64",
 		regex: regexp.MustCompile(`(?i)(riscv64)`),
 	}
+	// ArchUniversal matches macOS universal (fat) binaries, which contain code
+	// for several architectures.
+	ArchUniversal = Arch{
+		name:  "universal",
+		regex: regexp.MustCompile(`(?i)(universal|\bfat\b)`),
+	}
 )
 
 // a map from GOARCH values to internal architecture matchers
@@ -165,6 +172,26 @@ var goarchmap = map[string]Arch{
 	"riscv64": ArchRiscv64,
 }
 
+// a map from GOOS/GOARCH pairs to architectures that can also run on that
+// system, in order of preference. These are used when no asset is built for
+// the target architecture directly.
+var fallbackmap = map[string][]Arch{
+	"darwin/arm64":  {ArchUniversal, ArchAMD64},
+	"darwin/amd64":  {ArchUniversal},
+	"windows/arm64": {ArchAMD64, ArchI386},
+}
+
+// hasArch returns true if the given asset name mentions any known
+// architecture.
+func hasArch(s string) bool {
+	for _, a := range goarchmap {
+		if a.Match(s) {
+			return true
+		}
+	}
+	return ArchUniversal.Match(s)
+}
+
 // AllDetector matches every asset. If there is only one asset, it is returned
 // as a direct match. If there are multiple assets they are all returned as
 // candidates.
@@ -209,11 +236,29 @@ func (s *SingleAssetDetector) Detect(assets []string) (string, []string, error)
 type SystemDetector struct {
 	Os   OS
 	Arch Arch
+	// Fallbacks are tried in order when no asset matches Arch.
+	Fallbacks []Arch
+	// Fallback is set to the name of the fallback architecture selected by the
+	// last call to Detect, or empty if no fallback was needed.
+	Fallback string
+	// Level is the highest x86-64 microarchitecture level that the target
+	// can run (only used for amd64).
+	Level int
 }
 
 // NewSystemDetector returns a new detector for the given OS/Arch as given by
-// Go OS/Arch names.
+// Go OS/Arch names. An x86-64 microarchitecture level may be given with the
+// arch as 'amd64v3' or 'amd64/v3'. If no level is given, the host's level is
+// used when targeting the host system, and the baseline level otherwise.
 func NewSystemDetector(sos, sarch string) (*SystemDetector, error) {
+	sarch, level := splitAMD64Level(sarch)
+	if level == 0 && sarch == "amd64" {
+		level = 1
+		if sos == runtime.GOOS && sarch == runtime.GOARCH {
+			level = HostAMD64Level()
+		}
+	}
+
 	os, ok := goosmap[sos]
 	if !ok {
 		return nil, fmt.Errorf("unsupported target OS: %s", sos)
@@ -223,28 +268,55 @@ func NewSystemDetector(sos, sarch string) (*SystemDetector, error) {
 		return nil, fmt.Errorf("unsupported target arch: %s", sarch)
 	}
 	return &SystemDetector{
-		Os:   os,
-		Arch: arch,
+		Os:        os,
+		Arch:      arch,
+		Fallbacks: fallbackmap[sos+"/"+sarch],
+		Level:     level,
 	}, nil
 }
 
+// matchFallback returns true if the asset can be used with the fallback
+// architecture. Universal binaries for macOS are often published without any
+// architecture in the name, so assets that mention no architecture at all
+// are considered universal.
+func (d *SystemDetector) matchFallback(fb *Arch, s string) bool {
+	if fb.name == ArchAMD64.name && amd64Level(s) > 1 {
+		// emulated x86-64 is only guaranteed to support the baseline
+		return false
+	}
+	if fb.name == ArchUniversal.name {
+		return fb.Match(s) || !hasArch(s)
+	}
+	return fb.Match(s)
+}
+
 // Detect extracts the assets that match this detector's OS/Arch pair. If one
 // direct OS/Arch match is found, it is returned.  If multiple OS/Arch matches
-// are found they are returned as candidates. If multiple assets that only
-// match the OS are found, and no full OS/Arch matches are found, the OS
-// matches are returned as candidates. Otherwise all assets are returned as
-// candidates.
+// are found they are returned as candidates. If there are no OS/Arch matches,
+// the fallback architectures are tried in order in the same way. If multiple
+// assets that only match the OS are found, and no full OS/Arch or fallback
+// matches are found, the OS matches are returned as candidates. Otherwise all
+// assets are returned as candidates. When targeting amd64, assets built for an
+// x86-64 level higher than the detector's level are never selected, and the
+// highest compatible level is preferred among matches.
 func (d *SystemDetector) Detect(assets []string) (string, []string, error) {
 	var priority []string
 	var matches []string
 	var candidates []string
+	fallbacks := make([][]string, len(d.Fallbacks))
 	all := make([]string, 0, len(assets))
+	d.Fallback = ""
 	for _, a := range assets {
 		if strings.HasSuffix(a, ".sha256") || strings.HasSuffix(a, ".sha256sum") {
 			// skip checksums (they will be checked later by the verifier)
 			continue
 		}
 
+		if d.Arch.name == ArchAMD64.name && amd64Level(a) > d.Level {
+			// the target cannot run this asset
+			continue
+		}
+
 		os, extra := d.Os.Match(a)
 		if extra {
 			priority = append(priority, a)
@@ -255,9 +327,17 @@ func (d *SystemDetector) Detect(assets []string) (string, []string, error) {
 		}
 		if os {
 			candidates = append(candidates, a)
+			for i := range d.Fallbacks {
+				if !arch && d.matchFallback(&d.Fallbacks[i], a) {
+					fallbacks[i] = append(fallbacks[i], a)
+				}
+			}
 		}
 		all = append(all, a)
 	}
+	if d.Arch.name == ArchAMD64.name {
+		matches = bestAMD64Level(matches)
+	}
 	if len(priority) == 1 {
 		return priority[0], nil, nil
 	} else if len(priority) > 1 {
@@ -266,7 +346,18 @@ func (d *SystemDetector) Detect(assets []string) (string, []string, error) {
 		return matches[0], nil, nil
 	} else if len(matches) > 1 {
 		return "", matches, fmt.Errorf("%d matches found", len(matches))
-	} else if len(candidates) == 1 {
+	}
+	for i, fb := range fallbacks {
+		if len(fb) == 0 {
+			continue
+		}
+		d.Fallback = d.Fallbacks[i].name
+		if len(fb) == 1 {
+			return fb[0], nil, nil
+		}
+		return "", fb, fmt.Errorf("%d matches found for fallback architecture %s", len(fb), d.Fallback)
+	}
+	if len(candidates) == 1 {
 		return candidates[0], nil, nil
 	} else if len(candidates) > 1 {
 		return "", candidates, fmt.Errorf("%d candidates found (unsure architecture)", len(candidates))
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestDetectFallback(t *testing.T) {
+	tests := []struct {
+		system   string
+		assets   []string
+		want     string
+		fallback string
+	}{
+		{"darwin/arm64", []string{"tool-darwin-amd64", "tool-darwin-universal", "tool-darwin-arm64"}, "tool-darwin-arm64", ""},
+		{"darwin/arm64", []string{"tool-darwin-amd64", "tool-darwin-universal", "tool-linux-arm64"}, "tool-darwin-universal", "universal"},
+		{"darwin/arm64", []string{"tool-darwin-amd64", "tool-linux-arm64"}, "tool-darwin-amd64", "amd64"},
+		// emulated x86-64 only runs the baseline level
+		{"darwin/arm64", []string{"tool-darwin-amd64v3", "tool-darwin-amd64"}, "tool-darwin-amd64", "amd64"},
+		// an asset with no architecture is universal
+		{"darwin/arm64", []string{"tool-darwin-amd64", "tool-macos.tar.gz"}, "tool-macos.tar.gz", "universal"},
+		{"darwin/amd64", []string{"tool-darwin-arm64", "tool-macos.tar.gz"}, "tool-macos.tar.gz", "universal"},
+		{"windows/arm64", []string{"tool-windows-386.zip", "tool-windows-amd64.zip", "tool-windows-arm64.zip"}, "tool-windows-arm64.zip", ""},
+		{"windows/arm64", []string{"tool-windows-386.zip", "tool-windows-amd64.zip", "tool-linux-arm64"}, "tool-windows-amd64.zip", "amd64"},
+		{"windows/arm64", []string{"tool-windows-386.zip", "tool-linux-arm64"}, "tool-windows-386.zip", "386"},
+		// only macOS assets are universal without an architecture
+		{"windows/arm64", []string{"tool-windows.zip", "tool-windows-386.zip"}, "tool-windows-386.zip", "386"},
+	}
+	for _, tt := range tests {
+		sos, sarch, _ := Cut(tt.system, "/")
+		d, err := NewSystemDetector(sos, sarch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, candidates, err := d.Detect(tt.assets)
+		if err != nil {
+			t.Errorf("%s %v: %v (candidates %v)", tt.system, tt.assets, err, candidates)
+			continue
+		}
+		if got != tt.want || d.Fallback != tt.fallback {
+			t.Errorf("%s %v = %q (fallback %q), want %q (fallback %q)", tt.system, tt.assets, got, d.Fallback, tt.want, tt.fallback)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,6 +14,8 @@ import (
 	"github.com/zyedidia/eget/home"
 )
 
+// tokenFrom returns the secret given by 's', which is read from a file if it
+// is "@file".
 func tokenFrom(s string) (string, error) {
 	if strings.HasPrefix(s, "@") {
 		f, err := home.Expand(s[1:])
@@ -22,6 +23,9 @@ func tokenFrom(s string) (string, error) {
 			return "", err
 		}
 		b, err := os.ReadFile(f)
+		if err != nil {
+			return "", err
+		}
 		return strings.TrimRight(string(b), "\r\n"), nil
 	}
 	return s, nil
@@ -65,12 +69,7 @@ func Get(url string) (*http.Response, error) {
 
 	req = SetAuthHeader(req)
 
-	proxyClient := &http.Client{Transport: &http.Transport{
-		Proxy:           http.ProxyFromEnvironment,
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.DisableSSL},
-	}}
-
-	return proxyClient.Do(req)
+	return doMirrors(req)
 }
 
 type RateLimitJson struct {
@@ -111,7 +110,7 @@ func GetRateLimit() (RateLimit, error) {
 
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doMirrors(req)
 	if err != nil {
 		return RateLimit{}, err
 	}
@@ -132,7 +131,9 @@ func GetRateLimit() (RateLimit, error) {
 // Download the file at 'url' and write the http response body to 'out'. The
 // 'getbar' function allows the caller to construct a progress bar given the
 // size of the file being downloaded, and the download will write to the
-// returned progress bar.
+// returned progress bar. Downloads are stored in the cache, unless --no-cache
+// is given. A URL that is in the cache is revalidated with a conditional
+// request, and its data is only downloaded again if it has changed.
 func Download(url string, out io.Writer, getbar func(size int64) *pb.ProgressBar) error {
 	if IsLocalFile(url) {
 		f, err := os.Open(url)
@@ -144,21 +145,91 @@ func Download(url string, out io.Writer, getbar func(size int64) *pb.ProgressBar
 		return err
 	}
 
-	resp, err := Get(url)
-	if err != nil {
+	var cache *Cache
+	if !opts.NoCache {
+		var err error
+		cache, err = OpenCache()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "warning: not using the download cache:", err)
+		}
+	}
+	var cached *os.File
+	var entry *CacheEntry
+	if cache != nil {
+		var err error
+		cached, entry, err = cache.Open(url)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "warning: download cache:", err)
+		}
+		if cached != nil {
+			defer cached.Close()
+		}
+	}
+	useCached := func() error {
+		bar := getbar(entry.Size)
+		bar.Describe("Cached")
+		_, err := io.Copy(io.MultiWriter(out, bar), cached)
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
+	// the data is also written to a .part file, so that a failed download
+	// can be resumed
+	part, err := openPart(url)
+	if err != nil {
+		return err
+	}
+	if cached != nil && entry.validated() {
+		part.Cached = entry
+	}
+	dst := out
+	var cw *cacheWriter
+	if cache != nil {
+		cw, err = cache.Create()
 		if err != nil {
-			return err
+			fmt.Fprintln(os.Stderr, "warning: download cache:", err)
+		} else {
+			dst = io.MultiWriter(out, cw)
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		err = part.Fetch(dst, getbar)
+		if err == nil || err == errNotModified || !isRetryable(err) || attempt >= opts.Retries {
+			break
 		}
-		return fmt.Errorf("download error: %d: %s", resp.StatusCode, body)
+		retry(err.Error(), attempt, backoff(attempt))
 	}
+	if err != nil {
+		part.Close()
+		if cw != nil {
+			cw.Abort()
+		}
+		switch {
+		case err == errNotModified:
+			return useCached()
+		case cached != nil && part.source == "" && part.written == 0:
+			// the server could not be reached
+			fmt.Fprintf(os.Stderr, "warning: %v; using the download cached at %s\n", err, entry.Time.Format(time.RFC1123))
+			return useCached()
+		}
+		return err
+	}
+	part.Remove()
 
-	bar := getbar(resp.ContentLength)
-	_, err = io.Copy(io.MultiWriter(out, bar), resp.Body)
-	return err
+	if cw != nil {
+		if err := cw.Commit(url, part.etag, part.modified); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: download cache:", err)
+		}
+	}
+	return nil
+}
+
+// Uncache removes 'url' from the download cache, for example because its
+// data failed to verify.
+func Uncache(url string) {
+	if opts.NoCache {
+		return
+	}
+	if cache, err := OpenCache(); err == nil {
+		cache.Remove(url)
+	}
 }
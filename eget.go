@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -20,8 +19,24 @@ import (
 	pb "github.com/schollz/progressbar/v3"
 )
 
+var exitfns []func()
+
+// atExit registers a function to be called before eget exits, for removing
+// temporary files.
+func atExit(fn func()) {
+	exitfns = append(exitfns, fn)
+}
+
+func cleanup() {
+	for i := len(exitfns) - 1; i >= 0; i-- {
+		exitfns[i]()
+	}
+	exitfns = nil
+}
+
 func fatal(a ...interface{}) {
 	fmt.Fprintln(os.Stderr, a...)
+	cleanup()
 	os.Exit(1)
 }
 
@@ -71,6 +86,21 @@ func checksumAsset(asset string, assets []string) string {
 	return ""
 }
 
+// searches for a checksums file that lists the checksums of all assets, such
+// as checksums.txt or SHA256SUMS
+func checksumsAsset(assets []string) string {
+	for _, a := range assets {
+		name := strings.ToLower(assetName(a))
+		if strings.HasSuffix(name, ".sig") || strings.HasSuffix(name, ".asc") || strings.HasSuffix(name, ".pem") {
+			continue
+		}
+		if strings.Contains(name, "checksums") || strings.Contains(name, "sha256sums") {
+			return a
+		}
+	}
+	return ""
+}
+
 // Determine the appropriate Finder to use. If opts.URL is provided, we use
 // a DirectAssetFinder. Otherwise we use a GithubAssetFinder. When a Github
 // repo is provided, we assume the repo name is the 'tool' name (for direct
@@ -150,6 +180,30 @@ func getVerifier(sumAsset string, opts *Flags) (verifier Verifier, err error) {
 	return verifier, err
 }
 
+// fallbackArch returns the name of the fallback architecture that was selected
+// by the system detector during the last detection, if any.
+func fallbackArch(d Detector) string {
+	switch d := d.(type) {
+	case *SystemDetector:
+		return d.Fallback
+	case *DetectorChain:
+		return fallbackArch(d.system)
+	}
+	return ""
+}
+
+// targetSystem returns the OS/Arch pair that assets are being selected for.
+func targetSystem(opts *Flags) (string, string) {
+	if opts.System != "" && opts.System != "all" {
+		split := strings.Split(opts.System, "/")
+		if len(split) >= 2 {
+			arch, _ := splitAMD64Level(split[1])
+			return split[0], arch
+		}
+	}
+	return runtime.GOOS, runtime.GOARCH
+}
+
 // Determine the appropriate detector. If the --system is 'all', we use an
 // AllDetector, which will just return all assets. Otherwise we use the
 // --system pair provided by the user, or the runtime.GOOS/runtime.GOARCH
@@ -163,7 +217,7 @@ func getDetector(opts *Flags) (detector Detector, err error) {
 		if len(split) < 2 {
 			fatal("system descriptor must be os/arch")
 		}
-		system, err = NewSystemDetector(split[0], split[1])
+		system, err = NewSystemDetector(split[0], strings.Join(split[1:], "/"))
 	} else {
 		system, err = NewSystemDetector(runtime.GOOS, runtime.GOARCH)
 	}
@@ -190,16 +244,29 @@ func getDetector(opts *Flags) (detector Detector, err error) {
 	return detector, err
 }
 
+// assetName returns the file name of an asset given its URL, ignoring any
+// query string or fragment.
+func assetName(asset string) string {
+	if IsUrl(asset) {
+		if u, err := url.Parse(asset); err == nil && u.Path != "" {
+			return path.Base(u.Path)
+		}
+	}
+	return path.Base(asset)
+}
+
 // Determine which extractor to use. If --download-only is provided, we
 // just "extract" the downloaded archive to itself. Otherwise we try to
 // extract the literal file provided by --file, or by default we just
 // extract a binary with the tool name that was possibly auto-detected
-// above.
-func getExtractor(url, tool string, opts *Flags) (extractor Extractor, err error) {
+// above. The extractor for an archive is chosen based on the downloaded
+// data.
+func getExtractor(url, tool string, src Source, opts *Flags) (extractor Extractor, err error) {
+	name := assetName(url)
 	if opts.DLOnly {
 		extractor = &SingleFileExtractor{
-			Name:   path.Base(url),
-			Rename: path.Base(url),
+			Name:   name,
+			Rename: name,
 			Decompress: func(r io.Reader) (io.Reader, error) {
 				return r, nil
 			},
@@ -209,20 +276,23 @@ func getExtractor(url, tool string, opts *Flags) (extractor Extractor, err error
 		if err != nil {
 			return nil, err
 		}
-		extractor = NewExtractor(path.Base(url), tool, gc)
+		extractor = NewExtractor(name, tool, gc, src)
 	} else {
-		extractor = NewExtractor(path.Base(url), tool, &BinaryChooser{
+		extractor = NewExtractor(name, tool, &BinaryChooser{
 			Tool: tool,
-		})
+		}, src)
+	}
+	if ae, ok := extractor.(*ArchiveExtractor); ok {
+		ae.AllowSetuid = opts.AllowSetuid
 	}
 	return extractor, nil
 }
 
 // Write an extracted file to disk with a new name.
-func writeFile(data []byte, rename string, mode fs.FileMode) error {
+func writeFile(r io.Reader, rename string, mode fs.FileMode) error {
 	if rename[0] == '-' {
 		// if the output is '-', just print it to stdout
-		_, err := os.Stdout.Write(data)
+		_, err := io.Copy(os.Stdout, r)
 		return err
 	}
 
@@ -236,10 +306,38 @@ func writeFile(data []byte, rename string, mode fs.FileMode) error {
 		return err
 	}
 	defer f.Close()
-	_, err = f.Write(data)
+	_, err = io.Copy(f, r)
 	return err
 }
 
+// progressBar returns the progress bar for a download of 'size' bytes, which
+// is hidden with --quiet.
+func progressBar(size int64) *pb.ProgressBar {
+	var pbout io.Writer = os.Stderr
+	if opts.Quiet {
+		pbout = io.Discard
+	}
+	return pb.NewOptions64(size,
+		pb.OptionSetWriter(pbout),
+		pb.OptionShowBytes(true),
+		pb.OptionSetWidth(10),
+		pb.OptionThrottle(65*time.Millisecond),
+		pb.OptionShowCount(),
+		pb.OptionSpinnerType(14),
+		pb.OptionFullWidth(),
+		pb.OptionSetDescription("Downloading"),
+		pb.OptionOnCompletion(func() {
+			fmt.Fprint(pbout, "\n")
+		}),
+		pb.OptionSetTheme(pb.Theme{
+			Saucer:        "=",
+			SaucerHead:    ">",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}))
+}
+
 // Would really like generics to implement this...
 // Make the user select one of the choices and return the index of the
 // selection.
@@ -365,6 +463,35 @@ func main() {
 		os.Exit(0)
 	}
 
+	if cli.CleanCache || cli.CacheStats {
+		cache, err := OpenCache()
+		if err != nil {
+			fatal(err)
+		}
+		if cli.CleanCache {
+			count, size, err := cache.Clean()
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Printf("Removed %d files (%s) from the download cache\n", count, formatBytes(size))
+		} else {
+			fmt.Println(cache.Stats())
+		}
+		os.Exit(0)
+	}
+
+	if cli.SelfUpdate {
+		var output io.Writer = os.Stderr
+		if opts.Quiet {
+			output = io.Discard
+		}
+		if err := SelfUpdate(opts.Channel, output); err != nil {
+			fatal(err)
+		}
+		cleanup()
+		os.Exit(0)
+	}
+
 	target := ""
 
 	if len(args) > 0 {
@@ -397,13 +524,93 @@ func main() {
 	}
 
 	if opts.Remove {
+		removed := "Removed `%s`\n"
+		if opts.DryRun {
+			removed = "Would remove `%s`\n"
+		}
+		manifest, err := LoadManifest()
+		if err != nil {
+			fatal(err)
+		}
+		if installs := manifest.Find(repoName(target)); len(installs) != 0 {
+			files, err := Uninstall(manifest, installs, opts.DryRun)
+			for _, f := range files {
+				fmt.Printf(removed, f)
+			}
+			if err != nil {
+				fatal(err)
+			}
+			os.Exit(0)
+		}
+
+		// files that were not recorded are removed from $EGET_BIN
 		ebin := os.Getenv("EGET_BIN")
-		err := os.Remove(filepath.Join(ebin, target))
+		file := filepath.Join(ebin, target)
+		if opts.DryRun {
+			_, err = os.Lstat(file)
+		} else {
+			err = os.Remove(file)
+		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		fmt.Printf("Removed `%s`\n", filepath.Join(ebin, target))
+		fmt.Printf(removed, file)
+		os.Exit(0)
+	}
+
+	if opts.Rollback {
+		manifest, err := LoadManifest()
+		if err != nil {
+			fatal(err)
+		}
+		in, err := manifest.FindOne(target)
+		if err != nil {
+			fatal(err)
+		}
+		if err := Rollback(manifest, in); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Rolled back `%s`\n", in.Target)
+		os.Exit(0)
+	}
+
+	if opts.ListVers || opts.Use != "" || opts.Prune {
+		manifest, err := LoadManifest()
+		if err != nil {
+			fatal(err)
+		}
+		repo := repoName(target)
+		switch {
+		case opts.Use != "":
+			installs, err := UseVersion(manifest, repo, opts.Use)
+			if err != nil {
+				fatal(err)
+			}
+			for _, in := range installs {
+				fmt.Printf("Linked `%s` to `%s`\n", in.Target, in.Path)
+			}
+		case opts.Prune:
+			removed, err := PruneVersions(manifest, repo)
+			if err != nil {
+				fatal(err)
+			}
+			for _, dir := range removed {
+				fmt.Printf("Removed `%s`\n", dir)
+			}
+		default:
+			tags, active, err := ListVersions(manifest, repo)
+			if err != nil {
+				fatal(err)
+			}
+			for _, tag := range tags {
+				if active[tag] {
+					fmt.Printf("* %s\n", tag)
+				} else {
+					fmt.Printf("  %s\n", tag)
+				}
+			}
+		}
 		os.Exit(0)
 	}
 
@@ -422,6 +629,11 @@ func main() {
 		}
 		fatal(err)
 	}
+	project := projectName(finder, target)
+	tag := releaseTag(finder)
+	if opts.Versioned && tag == "" {
+		fatal("versioned installs need a release tag, which is only known for GitHub repositories")
+	}
 
 	detector, err := getDetector(&opts)
 	if err != nil {
@@ -443,49 +655,33 @@ func main() {
 		fatal(err)
 	}
 
-	// print the URL
-	fmt.Fprintf(output, "%s\n", url)
-
-	// download with progress bar
-	buf := &bytes.Buffer{}
-	err = Download(url, buf, func(size int64) *pb.ProgressBar {
-		var pbout io.Writer = os.Stderr
-		if opts.Quiet {
-			pbout = io.Discard
-		}
-		return pb.NewOptions64(size,
-			pb.OptionSetWriter(pbout),
-			pb.OptionShowBytes(true),
-			pb.OptionSetWidth(10),
-			pb.OptionThrottle(65*time.Millisecond),
-			pb.OptionShowCount(),
-			pb.OptionSpinnerType(14),
-			pb.OptionFullWidth(),
-			pb.OptionSetDescription("Downloading"),
-			pb.OptionOnCompletion(func() {
-				fmt.Fprint(pbout, "\n")
-			}),
-			pb.OptionSetTheme(pb.Theme{
-				Saucer:        "=",
-				SaucerHead:    ">",
-				SaucerPadding: " ",
-				BarStart:      "[",
-				BarEnd:        "]",
-			}))
-	})
-	if err != nil {
-		fatal(fmt.Sprintf("%s (URL: %s)", err, url))
+	fallback := fallbackArch(detector)
+	if fallback != "" {
+		sos, sarch := targetSystem(&opts)
+		fmt.Fprintf(output, "no %s/%s asset found, using %s fallback\n", sos, sarch, fallback)
 	}
 
-	body := buf.Bytes()
+	// print the URL
+	fmt.Fprintf(output, "%s\n", url)
 
 	sumAsset := checksumAsset(url, assets)
 	verifier, err := getVerifier(sumAsset, &opts)
 	if err != nil {
 		fatal(err)
 	}
-	err = verifier.Verify(body)
+
+	// download with progress bar, computing the checksum as the data arrives
+	body := NewSpool()
+	defer cleanup()
+	err = Download(url, io.MultiWriter(body, verifier), progressBar)
 	if err != nil {
+		fatal(fmt.Sprintf("%s (URL: %s)", err, url))
+	}
+
+	err = verifier.Verify()
+	if err != nil {
+		// don't use the data again if it was cached
+		Uncache(url)
 		fatal(err)
 	} else if opts.Verify == "" && sumAsset != "" {
 		fmt.Fprintf(output, "Checksum verified with %s\n", path.Base(sumAsset))
@@ -493,7 +689,7 @@ func main() {
 		fmt.Fprintf(output, "Checksum verified\n")
 	}
 
-	extractor, err := getExtractor(url, tool, &opts)
+	extractor, err := getExtractor(url, tool, body, &opts)
 	if err != nil {
 		fatal(err)
 	}
@@ -521,6 +717,50 @@ func main() {
 		bins = []ExtractedFile{bin}
 	}
 
+	manifest, err := LoadManifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: installs will not be recorded:", err)
+	}
+
+	// record an install of 'out' in the manifest, which links to 'vpath' if
+	// it is versioned
+	record := func(out, vpath, backup string) {
+		if manifest == nil {
+			return
+		}
+		abs, _ := filepath.Abs(out)
+		if backup != "" {
+			backup, _ = filepath.Abs(backup)
+		}
+		in := &Install{
+			Target:  abs,
+			Project: project,
+			URL:     url,
+			Tag:     tag,
+			Path:    vpath,
+			Backup:  backup,
+			Time:    time.Now(),
+		}
+		if err := recordFiles(in); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not record installed files:", err)
+		}
+		manifest.Record(in)
+		if err := manifest.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not record install:", err)
+		}
+	}
+
+	var installed []string
+	hooks := func(name string, commands []string) {
+		if len(commands) == 0 || opts.NoHooks {
+			return
+		}
+		env := hookEnv(tool, project, tag, url, installed)
+		if err := runHooks(name, commands, env, opts.HookFailure, output); err != nil {
+			fatal(err)
+		}
+	}
+
 	extract := func(bin ExtractedFile) {
 		mode := bin.Mode()
 
@@ -547,14 +787,126 @@ func main() {
 			}
 		}
 
-		err = bin.Extract(out)
+		if out == "-" {
+			err = bin.Extract(out)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Fprintf(output, "Extracted `%s` to `%s`\n", bin.ArchiveName, out)
+			return
+		}
+
+		// versioned installs are extracted into the directory for the
+		// release, and 'out' links to them
+		dest := out
+		if opts.Versioned {
+			vdir, err := versionDir(project, tag)
+			if err != nil {
+				fatal(err)
+			}
+			dest = filepath.Join(vdir, filepath.Base(out))
+		}
+
+		// extract next to the destination, and only move the result into
+		// place once it has been completely extracted and checked
+		stage, err := NewStage(dest)
+		if err != nil {
+			fatal(err)
+		}
+		err = bin.Extract(stage.Path)
+		if err != nil {
+			fatal(err)
+		}
+
+		// universal binaries are only recognized by name, so make sure the
+		// extracted executable really contains code for the target
+		if fallback == ArchUniversal.name && !bin.Dir && mode&0111 != 0 {
+			sos, sarch := targetSystem(&opts)
+			archs := []string{sarch}
+			for _, fb := range fallbackmap[sos+"/"+sarch] {
+				if fb.name != ArchUniversal.name {
+					archs = append(archs, fb.name)
+				}
+			}
+			arch, err := VerifyMachO(stage.Path, archs)
+			if err != nil {
+				fatal(err)
+			}
+			if arch != sarch {
+				fmt.Fprintf(output, "`%s` has no %s code, so it will run as %s under Rosetta\n", bin.Name, sarch, arch)
+			}
+		}
+
+		backup, err := stage.Commit()
 		if err != nil {
 			fatal(err)
 		}
+		var vpath string
+		if opts.Versioned {
+			// reinstalling a version replaces it without a backup
+			if backup != "" {
+				os.RemoveAll(backup)
+			}
+			vpath, _ = filepath.Abs(dest)
+			backup, err = LinkVersion(out, vpath)
+			if err != nil {
+				fatal(err)
+			}
+		}
+		record(out, vpath, backup)
+		installed = append(installed, out)
 
 		fmt.Fprintf(output, "Extracted `%s` to `%s`\n", bin.ArchiveName, out)
 	}
 
+	// also install the man pages and completions in the archive
+	installExtras := func() {
+		// extras are named after the repository or one of the binaries
+		tools := []string{tool}
+		for _, out := range installed {
+			tools = append(tools, strings.TrimSuffix(filepath.Base(out), ".exe"))
+		}
+		extras := NewExtractor(assetName(url), tool, &ExtraChooser{Tools: tools}, body)
+		ae, ok := extras.(*ArchiveExtractor)
+		if !ok {
+			fmt.Fprintln(output, "no man pages or completions to install: the asset is not an archive")
+			return
+		}
+		ae.AllowSetuid = opts.AllowSetuid
+		file, files, err := ae.Extract(body, true)
+		if err == nil {
+			files = []ExtractedFile{file}
+		} else if len(files) == 0 {
+			fmt.Fprintln(output, "no man pages or completions found in the archive")
+			return
+		}
+		seen := make(map[string]bool)
+		for _, f := range files {
+			kind, name := extraKind(f.ArchiveName, tools)
+			out := opts.ExtraDirs.extraPath(kind, name)
+			if seen[out] {
+				// archives may include the same completion in two forms
+				continue
+			}
+			seen[out] = true
+			stage, err := NewStage(out)
+			if err != nil {
+				fatal(err)
+			}
+			if err := f.Extract(stage.Path); err != nil {
+				fatal(err)
+			}
+			backup, err := stage.Commit()
+			if err != nil {
+				fatal(err)
+			}
+			record(out, "", backup)
+			installed = append(installed, out)
+			fmt.Fprintf(output, "Installed %s `%s` to `%s`\n", kind, f.ArchiveName, out)
+		}
+	}
+
+	hooks("pre_install", opts.PreInstall)
 	if opts.All {
 		for _, bin := range bins {
 			extract(bin)
@@ -562,4 +914,8 @@ func main() {
 	} else {
 		extract(bin)
 	}
+	if opts.FullInstall && !opts.DLOnly && opts.Output != "-" {
+		installExtras()
+	}
+	hooks("post_install", opts.PostInstall)
 }
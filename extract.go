@@ -2,26 +2,27 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/gobwas/glob"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 // An Extractor reads in some archive data and extracts a particular file from
 // it. If there are multiple candidates it returns a list and an error
 // explaining what happened.
 type Extractor interface {
-	Extract(data []byte, multiple bool) (ExtractedFile, []ExtractedFile, error)
+	Extract(src Source, multiple bool) (ExtractedFile, []ExtractedFile, error)
 }
 
 // An ExtractedFile contains the data, name, and permissions of a file in the
@@ -58,30 +59,52 @@ type Chooser interface {
 	Choose(name string, dir bool, mode fs.FileMode) (direct bool, possible bool)
 }
 
+func gunzipper(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func b2unzipper(r io.Reader) (io.Reader, error) {
+	return bzip2.NewReader(r), nil
+}
+
+func xunzipper(r io.Reader) (io.Reader, error) {
+	return xz.NewReader(bufio.NewReader(r))
+}
+
+func lzmaunzipper(r io.Reader) (io.Reader, error) {
+	return lzma.NewReader(bufio.NewReader(r))
+}
+
+func zstdunzipper(r io.Reader) (io.Reader, error) {
+	return zstd.NewReader(r)
+}
+
+func nounzipper(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
 // NewExtractor constructs an extractor for the given archive file using the
-// given chooser. It will construct extractors for files ending in '.tar.gz',
-// '.tar.bz2', '.tar', '.zip'. After these matches, if the file ends with
-// '.gz', '.bz2' it will be decompressed and copied. Other files will simply
-// be copied without any decompression or extraction.
-func NewExtractor(filename string, tool string, chooser Chooser) Extractor {
+// given chooser. The archive and compression formats are detected from the
+// contents of src if possible. Otherwise, it will construct extractors for
+// files ending in '.tar.gz', '.tar.bz2', '.tar', '.zip', '.7z', '.deb',
+// '.rpm'. After these matches, if the file ends with '.gz', '.bz2' it will be
+// decompressed and copied. Other files will simply be copied without any
+// decompression or extraction.
+func NewExtractor(filename string, tool string, chooser Chooser, src Source) Extractor {
+	e := newExtractor(filename, tool, chooser, src)
+	if ae, ok := e.(*ArchiveExtractor); ok {
+		ae.Name = filename
+	}
+	return e
+}
+
+func newExtractor(filename string, tool string, chooser Chooser, src Source) Extractor {
 	if tool == "" {
 		tool = filename
 	}
 
-	gunzipper := func(r io.Reader) (io.Reader, error) {
-		return gzip.NewReader(r)
-	}
-	b2unzipper := func(r io.Reader) (io.Reader, error) {
-		return bzip2.NewReader(r), nil
-	}
-	xunzipper := func(r io.Reader) (io.Reader, error) {
-		return xz.NewReader(bufio.NewReader(r))
-	}
-	zstdunzipper := func(r io.Reader) (io.Reader, error) {
-		return zstd.NewReader(r)
-	}
-	nounzipper := func(r io.Reader) (io.Reader, error) {
-		return r, nil
+	if e := sniffExtractor(src, filename, tool, chooser); e != nil {
+		return e
 	}
 
 	switch {
@@ -120,6 +143,21 @@ func NewExtractor(filename string, tool string, chooser Chooser) Extractor {
 			Ar:   NewZipArchive,
 			File: chooser,
 		}
+	case strings.HasSuffix(filename, ".7z"):
+		return &ArchiveExtractor{
+			Ar:   NewSevenZipArchive,
+			File: chooser,
+		}
+	case strings.HasSuffix(filename, ".deb"):
+		return &ArchiveExtractor{
+			Ar:   NewDebArchive,
+			File: chooser,
+		}
+	case strings.HasSuffix(filename, ".rpm"):
+		return &ArchiveExtractor{
+			Ar:   NewRpmArchive,
+			File: chooser,
+		}
 	case strings.HasSuffix(filename, ".gz"):
 		return &SingleFileExtractor{
 			Rename:     tool,
@@ -153,15 +191,31 @@ func NewExtractor(filename string, tool string, chooser Chooser) Extractor {
 	}
 }
 
-type ArchiveFn func(data []byte, decomp DecompFn) (Archive, error)
+type ArchiveFn func(src Source, decomp DecompFn) (Archive, error)
 type DecompFn func(r io.Reader) (io.Reader, error)
 
+// An ArchiveExtractor extracts files from an archive. If no file is chosen in
+// the archive itself, it looks inside the archives that it contains. Files in
+// nested archives are named with the path of each archive followed by '!/',
+// such as 'bundle.zip!/tool.tar.gz!/bin/tool'.
 type ArchiveExtractor struct {
 	File       Chooser
 	Ar         ArchiveFn
 	Decompress DecompFn
+	Name       string // name of the archive, which starts nested paths
+	Prefix     string // path of this archive if it is nested
+	Depth      int    // number of archives this one is nested in
+
+	// extract files with the setuid or setgid bit instead of rejecting them
+	AllowSetuid bool
 }
 
+// separates the path of a nested archive from the paths of its files
+const nestSep = "!/"
+
+// the maximum depth of nested archives that will be searched
+const maxNestDepth = 3
+
 type link struct {
 	newname string
 	oldname string
@@ -180,14 +234,25 @@ func (l link) Write() error {
 	return os.Link(l.oldname, l.newname)
 }
 
-func (a *ArchiveExtractor) Extract(data []byte, multiple bool) (ExtractedFile, []ExtractedFile, error) {
+// Extract scans the archive once. The data of files that are chosen is copied
+// to a spool so that it can be written later without reading the archive
+// again, while directories are read from the archive again when they are
+// extracted. The entries of each candidate are checked during the scan, and
+// a candidate with unsafe entries returns an UnsafeError when it is
+// extracted.
+func (a *ArchiveExtractor) Extract(src Source, multiple bool) (ExtractedFile, []ExtractedFile, error) {
 	var candidates []ExtractedFile
 	var dirs []string
+	var checks []*entryChecker // checks for the entries in each of dirs
+	var nested []string
 
-	ar, err := a.Ar(data, a.Decompress)
+	spool := NewSpool()
+
+	ar, err := a.Ar(src, a.Decompress)
 	if err != nil {
 		return ExtractedFile{}, nil, err
 	}
+	defer closeArchive(ar)
 	for {
 		f, err := ar.Next()
 		if err == io.EOF {
@@ -197,8 +262,9 @@ func (a *ArchiveExtractor) Extract(data []byte, multiple bool) (ExtractedFile, [
 			return ExtractedFile{}, nil, fmt.Errorf("extract: %w", err)
 		}
 		var hasdir bool
-		for _, d := range dirs {
+		for i, d := range dirs {
 			if strings.HasPrefix(f.Name, d) {
+				checks[i].check(f)
 				hasdir = true
 				break
 			}
@@ -206,84 +272,59 @@ func (a *ArchiveExtractor) Extract(data []byte, multiple bool) (ExtractedFile, [
 		if hasdir {
 			continue
 		}
-		direct, possible := a.File.Choose(f.Name, f.Dir(), f.Mode)
+		direct, possible := a.File.Choose(a.Prefix+f.Name, f.Dir(), f.Mode)
+		if !direct && !possible && !f.Dir() && isArchiveName(f.Name) {
+			nested = append(nested, f.Name)
+		}
 		if direct || possible {
 			name := rename(f.Name, f.Name)
-
-			fdata, err := ar.ReadAll()
-			if err != nil {
-				return ExtractedFile{}, nil, fmt.Errorf("extract: %w", err)
-			}
+			check := newEntryChecker(f.Name, a.AllowSetuid)
+			check.check(f)
 
 			var extract func(to string) error
 			if !f.Dir() {
-				extract = func(to string) error {
-					return writeFile(fdata, to, modeFrom(name, f.Mode))
+				off := spool.Size()
+				fr, err := ar.Open()
+				if err != nil {
+					return ExtractedFile{}, nil, fmt.Errorf("extract: %w", err)
 				}
-			} else {
-				dirs = append(dirs, f.Name)
+				n, err := io.Copy(spool, fr)
+				if err != nil {
+					return ExtractedFile{}, nil, fmt.Errorf("extract: %w", err)
+				}
+				mode := modeFrom(name, f.Mode)
 				extract = func(to string) error {
-					ar, err := a.Ar(data, a.Decompress)
-					if err != nil {
+					if err := check.err(); err != nil {
 						return err
 					}
-					var links []link
-					for {
-						subf, err := ar.Next()
-						if err == io.EOF {
-							break
-						} else if err != nil {
-							return fmt.Errorf("extract: %w", err)
-						} else if !strings.HasPrefix(subf.Name, f.Name) {
-							continue
-						} else if subf.Dir() {
-							os.MkdirAll(filepath.Join(to, subf.Name[len(f.Name):]), 0755)
-							continue
-						} else if subf.Type == TypeLink || subf.Type == TypeSymlink {
-							newname := filepath.Join(to, subf.Name[len(f.Name):])
-							oldname := subf.LinkName
-							links = append(links, link{
-								newname: newname,
-								oldname: oldname,
-								sym:     subf.Type == TypeSymlink,
-							})
-							continue
-						}
-
-						fdata, err := ar.ReadAll()
-						if err != nil {
-							return fmt.Errorf("extract: %w", err)
-						}
-						name = filepath.Join(to, subf.Name[len(f.Name):])
-						err = writeFile(fdata, name, subf.Mode)
-						if err != nil {
-							return fmt.Errorf("extract: %w", err)
-						}
-					}
-					for _, l := range links {
-						if err := l.Write(); err != nil && err != os.ErrExist {
-							return fmt.Errorf("extract: %w", err)
-						}
-					}
-					return nil
+					return writeFile(io.NewSectionReader(spool, off, n), to, mode)
 				}
+			} else {
+				dirs = append(dirs, f.Name)
+				checks = append(checks, check)
+				extract = a.extractDir(src, f.Name, check)
 			}
 
 			ef := ExtractedFile{
 				Name:        name,
-				ArchiveName: f.Name,
+				ArchiveName: a.Prefix + f.Name,
 				mode:        f.Mode,
 				Extract:     extract,
 				Dir:         f.Dir(),
 			}
 			if direct && !multiple {
-				return ef, nil, err
-			}
-			if err == nil {
-				candidates = append(candidates, ef)
+				return ef, nil, nil
 			}
+			candidates = append(candidates, ef)
 		}
 	}
+	if len(nested) > 0 && a.Depth < maxNestDepth && (len(candidates) == 0 || isNestedChooser(a.File)) {
+		found, err := a.extractNested(src, nested, multiple)
+		if err != nil {
+			return ExtractedFile{}, nil, err
+		}
+		candidates = append(candidates, found...)
+	}
 	if len(candidates) == 1 {
 		return candidates[0], nil, nil
 	} else if len(candidates) == 0 {
@@ -292,6 +333,138 @@ func (a *ArchiveExtractor) Extract(data []byte, multiple bool) (ExtractedFile, [
 	return ExtractedFile{}, candidates, fmt.Errorf("%d candidates for target %v found", len(candidates), a.File)
 }
 
+// extractDir returns a function that extracts the contents of the directory
+// 'dir' in the archive, if 'check' finds that all of them are safe.
+func (a *ArchiveExtractor) extractDir(src Source, dir string, check *entryChecker) func(to string) error {
+	return func(to string) error {
+		if err := check.err(); err != nil {
+			return err
+		}
+
+		ar, err := a.Ar(src, a.Decompress)
+		if err != nil {
+			return err
+		}
+		defer closeArchive(ar)
+		// the scan may have stopped before the end of the directory, so the
+		// entries are checked again as they are written
+		wcheck := newEntryChecker(dir, a.AllowSetuid)
+		var links []link
+		for {
+			subf, err := ar.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("extract: %w", err)
+			} else if !strings.HasPrefix(subf.Name, dir) {
+				continue
+			}
+			wcheck.check(subf)
+			if len(wcheck.bad) > 0 {
+				// stop writing, but find the rest of the unsafe entries
+				continue
+			}
+
+			name, err := safeJoin(to, subf.Name[len(dir):])
+			if err != nil {
+				return fmt.Errorf("extract: %w", err)
+			}
+			if subf.Dir() {
+				os.MkdirAll(name, 0755)
+				continue
+			} else if subf.Type == TypeLink || subf.Type == TypeSymlink {
+				oldname := subf.LinkName
+				if subf.Type == TypeLink {
+					// hard links refer to other files in the archive
+					oldname, err = safeJoin(to, strings.TrimPrefix(oldname, dir))
+					if err != nil {
+						return fmt.Errorf("extract: %w", err)
+					}
+				}
+				links = append(links, link{
+					newname: name,
+					oldname: oldname,
+					sym:     subf.Type == TypeSymlink,
+				})
+				continue
+			}
+
+			fr, err := ar.Open()
+			if err != nil {
+				return fmt.Errorf("extract: %w", err)
+			}
+			err = writeFile(fr, name, subf.Mode)
+			if err != nil {
+				return fmt.Errorf("extract: %w", err)
+			}
+		}
+		if err := wcheck.err(); err != nil {
+			return err
+		}
+		for _, l := range links {
+			if err := l.Write(); err != nil && err != os.ErrExist {
+				return fmt.Errorf("extract: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// extractNested searches the archives called 'names' within this archive and
+// returns the candidates found inside them. Each nested archive is copied to a
+// spool while it is searched.
+func (a *ArchiveExtractor) extractNested(src Source, names []string, multiple bool) ([]ExtractedFile, error) {
+	ar, err := a.Ar(src, a.Decompress)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive(ar)
+
+	var found []ExtractedFile
+	for len(names) > 0 {
+		f, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("extract: %w", err)
+		}
+		if f.Name != names[0] {
+			continue
+		}
+		names = names[1:]
+
+		fr, err := ar.Open()
+		if err != nil {
+			return nil, fmt.Errorf("extract: %w", err)
+		}
+		inner := NewSpool()
+		if _, err := io.Copy(inner, fr); err != nil {
+			return nil, fmt.Errorf("extract: %w", err)
+		}
+		ie, ok := NewExtractor(filepath.Base(f.Name), "", a.File, inner).(*ArchiveExtractor)
+		if !ok {
+			inner.Close()
+			continue
+		}
+		prefix := a.Prefix
+		if prefix == "" && a.Name != "" {
+			prefix = a.Name + nestSep
+		}
+		ie.Prefix = prefix + f.Name + nestSep
+		ie.Depth = a.Depth + 1
+		ie.AllowSetuid = a.AllowSetuid
+
+		bin, bins, err := ie.Extract(inner, multiple)
+		if err == nil {
+			found = append(found, bin)
+		} else {
+			found = append(found, bins...)
+		}
+	}
+	return found, nil
+}
+
 // SingleFileExtractor extracts files called 'Name' after decompressing the
 // file with 'Decompress'.
 type SingleFileExtractor struct {
@@ -300,24 +473,18 @@ type SingleFileExtractor struct {
 	Decompress func(r io.Reader) (io.Reader, error)
 }
 
-func (sf *SingleFileExtractor) Extract(data []byte, multiple bool) (ExtractedFile, []ExtractedFile, error) {
+func (sf *SingleFileExtractor) Extract(src Source, multiple bool) (ExtractedFile, []ExtractedFile, error) {
 	name := rename(sf.Name, sf.Rename)
 	return ExtractedFile{
 		Name:        name,
 		ArchiveName: sf.Name,
 		mode:        0666,
 		Extract: func(to string) error {
-			r := bytes.NewReader(data)
-			dr, err := sf.Decompress(r)
-			if err != nil {
-				return err
-			}
-
-			decdata, err := io.ReadAll(dr)
+			dr, err := sf.Decompress(sourceReader(src))
 			if err != nil {
 				return err
 			}
-			return writeFile(decdata, to, modeFrom(name, 0666))
+			return writeFile(dr, to, modeFrom(name, 0666))
 		},
 	}, nil, nil
 }
@@ -367,11 +534,23 @@ func (b *BinaryChooser) String() string {
 }
 
 func isDefinitelyNotExec(file string) bool {
-	// file is definitely not executable if it is .deb, .1, or .txt
-	return strings.HasSuffix(file, ".deb") || strings.HasSuffix(file, ".1") ||
+	// file is definitely not executable if it is an archive, .1, or .txt
+	return isArchiveName(file) || strings.HasSuffix(file, ".1") ||
 		strings.HasSuffix(file, ".txt")
 }
 
+// isArchiveName returns true if the file name has the extension of an archive
+// that can be extracted.
+func isArchiveName(file string) bool {
+	file = strings.ToLower(file)
+	for _, ext := range []string{".zip", ".7z", ".deb", ".rpm", ".tgz", ".tbz", ".txz"} {
+		if strings.HasSuffix(file, ext) {
+			return true
+		}
+	}
+	return strings.HasSuffix(file, ".tar") || strings.Contains(path.Base(file), ".tar.")
+}
+
 func isExec(file string, mode os.FileMode) bool {
 	if isDefinitelyNotExec(file) {
 		return false
@@ -423,6 +602,13 @@ func (gc *GlobChooser) Choose(name string, dir bool, mode fs.FileMode) (bool, bo
 	return false, gc.g.Match(filepath.Base(name)) || gc.g.Match(name)
 }
 
+// isNestedChooser returns true if the chooser selects files inside nested
+// archives explicitly.
+func isNestedChooser(c Chooser) bool {
+	gc, ok := c.(*GlobChooser)
+	return ok && strings.Contains(gc.expr, nestSep)
+}
+
 func (gc *GlobChooser) String() string {
 	return fmt.Sprintf("`%s`", gc.expr)
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractNested(t *testing.T) {
+	inner := makeGzip(t, makeTar(t, []tarEntry{
+		{name: "bin/tool", typ: tar.TypeReg, mode: 0755, data: "linux binary"},
+		{name: "README", typ: tar.TypeReg, mode: 0644, data: "readme"},
+	}))
+	other := makeGzip(t, makeTar(t, []tarEntry{
+		{name: "bin/tool", typ: tar.TypeReg, mode: 0755, data: "darwin binary"},
+	}))
+	bundle := makeZip(t, map[string][]byte{
+		"tool-linux.tar.gz":  inner,
+		"tool-darwin.tar.gz": other,
+		"LICENSE":            []byte("license"),
+	})
+
+	tests := []struct {
+		glob string
+		want string // archive name of the file
+		data string
+	}{
+		{"bundle.zip!/tool-linux.tar.gz!/bin/tool", "bundle.zip!/tool-linux.tar.gz!/bin/tool", "linux binary"},
+		{"bundle.zip!/tool-darwin.tar.gz!/bin/tool", "bundle.zip!/tool-darwin.tar.gz!/bin/tool", "darwin binary"},
+		{"README", "bundle.zip!/tool-linux.tar.gz!/README", "readme"},
+		{"LICENSE", "LICENSE", "license"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			gc, err := NewGlobChooser(tt.glob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewExtractor("bundle.zip", "tool", gc, bytes.NewReader(bundle))
+			bin, bins, err := e.Extract(bytes.NewReader(bundle), false)
+			if err != nil {
+				t.Fatalf("%v (candidates %v)", err, bins)
+			}
+			if bin.ArchiveName != tt.want {
+				t.Errorf("archive name = %q, want %q", bin.ArchiveName, tt.want)
+			}
+			out := filepath.Join(t.TempDir(), "out")
+			if err := bin.Extract(out); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := os.ReadFile(out); string(b) != tt.data {
+				t.Errorf("extracted %q, want %q", b, tt.data)
+			}
+		})
+	}
+}
+
+func TestExtractNestedCandidates(t *testing.T) {
+	// a binary in each nested archive gives a candidate for each of them
+	bundle := makeZip(t, map[string][]byte{
+		"tool-linux.tar.gz": makeGzip(t, makeTar(t, []tarEntry{
+			{name: "bin/tool", typ: tar.TypeReg, mode: 0755, data: "linux"},
+		})),
+		"tool-darwin.zip": makeZip(t, map[string][]byte{"tool": []byte("darwin")}),
+	})
+	gc, err := NewGlobChooser("tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExtractor("bundle.zip", "tool", gc, bytes.NewReader(bundle))
+	_, bins, err := e.Extract(bytes.NewReader(bundle), true)
+	if err == nil {
+		t.Fatal("expected multiple candidates")
+	}
+	names := make(map[string]bool)
+	for _, b := range bins {
+		names[b.ArchiveName] = true
+	}
+	for _, want := range []string{"bundle.zip!/tool-linux.tar.gz!/bin/tool", "bundle.zip!/tool-darwin.zip!/tool"} {
+		if !names[want] {
+			t.Errorf("%s is not a candidate: %v", want, names)
+		}
+	}
+}
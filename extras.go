@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Kinds of extra files that a full install places next to the binary.
+const (
+	ExtraMan  = "man page"
+	ExtraBash = "bash completion"
+	ExtraZsh  = "zsh completion"
+	ExtraFish = "fish completion"
+)
+
+// ExtraDirs are the directories that extra files are installed to.
+type ExtraDirs struct {
+	Man  string // contains a man<N> directory for each section
+	Bash string
+	Zsh  string
+	Fish string
+}
+
+// DefaultExtraDirs returns the standard locations in the user's data
+// directory, where man and the shells look for man pages and completions.
+func DefaultExtraDirs() (ExtraDirs, error) {
+	data, err := userDataDir()
+	if err != nil {
+		return ExtraDirs{}, err
+	}
+	return ExtraDirs{
+		Man:  filepath.Join(data, "man"),
+		Bash: filepath.Join(data, "bash-completion", "completions"),
+		Zsh:  filepath.Join(data, "zsh", "site-functions"),
+		Fish: filepath.Join(data, "fish", "vendor_completions.d"),
+	}, nil
+}
+
+var (
+	manrgx    = regexp.MustCompile(`\.([1-9])(\.gz)?$`)
+	mandirrgx = regexp.MustCompile(`^man[1-9]?$`)
+)
+
+// extraKind returns the kind of extra file that 'name' is, or the empty
+// string if it is not one, along with the name to install it as. Man pages
+// and completions are recognized by their extension, but only if they are in
+// a man or completions directory, or are named after one of 'tools' (the
+// names of the repository and of the installed binaries), so that files
+// such as libfoo.so.1 or install.bash are not installed. Completions without
+// an extension are recognized by being in a directory for completions.
+func extraKind(name string, tools []string) (kind, rename string) {
+	name = strings.TrimSuffix(name, "/")
+	base := path.Base(name)
+	var man, completions bool
+	for _, dir := range strings.Split(strings.ToLower(path.Dir(name)), "/") {
+		man = man || mandirrgx.MatchString(dir)
+		completions = completions || strings.Contains(dir, "complet")
+	}
+	// the name without its extensions, such as 'tool' for tool.1.gz or
+	// _tool.zsh
+	stem := strings.TrimPrefix(base, "_")
+	if i := strings.Index(stem, "."); i >= 0 {
+		stem = stem[:i]
+	}
+	named := false
+	for _, tool := range tools {
+		named = named || (tool != "" && stem == tool)
+	}
+	shell := completions || named
+
+	switch {
+	case manrgx.MatchString(base):
+		if !man && !named {
+			return "", ""
+		}
+		return ExtraMan, base
+	case !shell:
+		return "", ""
+	case strings.HasSuffix(base, ".fish"):
+		return ExtraFish, base
+	case strings.HasSuffix(base, ".zsh"):
+		// zsh finds completion functions by their name, which starts with '_'
+		return ExtraZsh, "_" + strings.TrimPrefix(strings.TrimSuffix(base, ".zsh"), "_")
+	case strings.HasSuffix(base, ".bash"):
+		// bash-completion finds completions by the name of the command
+		return ExtraBash, strings.TrimSuffix(base, ".bash")
+	case strings.HasSuffix(base, ".bash-completion"):
+		return ExtraBash, strings.TrimSuffix(base, ".bash-completion")
+	case completions && strings.HasPrefix(base, "_") && !strings.Contains(base, "."):
+		return ExtraZsh, base
+	case completions && strings.Contains(strings.ToLower(path.Dir(name)), "bash") && !strings.Contains(base, "."):
+		return ExtraBash, base
+	}
+	return "", ""
+}
+
+// extraPath returns the path to install an extra file of the given kind to.
+func (d ExtraDirs) extraPath(kind, name string) string {
+	switch kind {
+	case ExtraMan:
+		section := manrgx.FindStringSubmatch(name)[1]
+		return filepath.Join(d.Man, "man"+section, name)
+	case ExtraBash:
+		return filepath.Join(d.Bash, name)
+	case ExtraZsh:
+		return filepath.Join(d.Zsh, name)
+	case ExtraFish:
+		return filepath.Join(d.Fish, name)
+	}
+	return ""
+}
+
+// An ExtraChooser selects man pages and shell completions for Tools.
+type ExtraChooser struct {
+	Tools []string
+}
+
+func (ec *ExtraChooser) Choose(name string, dir bool, mode fs.FileMode) (bool, bool) {
+	if dir {
+		return false, false
+	}
+	kind, _ := extraKind(name, ec.Tools)
+	return false, kind != ""
+}
+
+func (ec *ExtraChooser) String() string {
+	return "man pages or completions"
+}
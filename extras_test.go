@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExtraKind(t *testing.T) {
+	tools := []string{"ripgrep", "rg"}
+	tests := []struct {
+		name   string
+		kind   string
+		rename string
+	}{
+		// named after the binary
+		{"ripgrep-14.0.0/doc/rg.1", ExtraMan, "rg.1"},
+		{"rg.1.gz", ExtraMan, "rg.1.gz"},
+		{"rg.bash", ExtraBash, "rg"},
+		{"rg.bash-completion", ExtraBash, "rg"},
+		{"rg.fish", ExtraFish, "rg.fish"},
+		{"rg.zsh", ExtraZsh, "_rg"},
+		{"_rg.zsh", ExtraZsh, "_rg"},
+		// in a man or completions directory
+		{"share/man/man1/rg-search.1", ExtraMan, "rg-search.1"},
+		{"man/other.5.gz", ExtraMan, "other.5.gz"},
+		{"complete/_rg", ExtraZsh, "_rg"},
+		{"completions/other.fish", ExtraFish, "other.fish"},
+		{"contrib/completion/bash/rg", ExtraBash, "rg"},
+		{"autocomplete/other.bash", ExtraBash, "other"},
+		// not man pages or completions
+		{"lib/libfoo.so.1", "", ""},
+		{"libfoo.so.1", "", ""},
+		{"tool-1.0.1", "", ""},
+		{"rg-1.0.1", "", ""},
+		{"install.bash", "", ""},
+		{"scripts/env.zsh", "", ""},
+		{"hooks/setup.fish", "", ""},
+		{"_config", "", ""},
+		{"bin/rg", "", ""},
+		{"complete/README.md", "", ""},
+	}
+	for _, tt := range tests {
+		kind, rename := extraKind(tt.name, tools)
+		if kind != tt.kind || rename != tt.rename {
+			t.Errorf("extraKind(%q) = %q, %q, want %q, %q", tt.name, kind, rename, tt.kind, tt.rename)
+		}
+	}
+}
+
+func TestExtraPath(t *testing.T) {
+	d := ExtraDirs{Man: "man", Bash: "bash", Zsh: "zsh", Fish: "fish"}
+	tests := []struct {
+		kind, name, want string
+	}{
+		{ExtraMan, "rg.1", filepath.Join("man", "man1", "rg.1")},
+		{ExtraMan, "rg.5.gz", filepath.Join("man", "man5", "rg.5.gz")},
+		{ExtraBash, "rg", filepath.Join("bash", "rg")},
+		{ExtraZsh, "_rg", filepath.Join("zsh", "_rg")},
+		{ExtraFish, "rg.fish", filepath.Join("fish", "rg.fish")},
+	}
+	for _, tt := range tests {
+		if got := d.extraPath(tt.kind, tt.name); got != tt.want {
+			t.Errorf("extraPath(%q, %q) = %q, want %q", tt.kind, tt.name, got, tt.want)
+		}
+	}
+}
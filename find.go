@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 )
@@ -54,11 +55,24 @@ type GithubAssetFinder struct {
 	Tag        string
 	Prerelease bool
 	MinTime    time.Time // release must be after MinTime to be found
+	Release    string    // tag of the release that was found
 }
 
 var ErrNoUpgrade = errors.New("requested release is not more recent than current version")
 
+// Find returns the assets of the release. If a GitHub token is available,
+// the release is found with the GraphQL API, which needs fewer requests, and
+// the REST API is used if that fails.
 func (f *GithubAssetFinder) Find() ([]string, error) {
+	if _, err := getGithubToken(); err == nil && !opts.NoGraphQL {
+		assets, err := f.findGraphQL()
+		var gqlErr *GraphQLError
+		if !errors.As(err, &gqlErr) {
+			return assets, err
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v; using the REST API\n", err)
+	}
+
 	if f.Prerelease && f.Tag == "latest" {
 		tag, err := f.getLatestTag()
 		if err != nil {
@@ -69,7 +83,7 @@ func (f *GithubAssetFinder) Find() ([]string, error) {
 
 	// query github's API for this repo/tag pair.
 	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/%s", f.Repo, f.Tag)
-	resp, err := Get(url)
+	resp, err := GetAPI(url)
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +121,7 @@ func (f *GithubAssetFinder) Find() ([]string, error) {
 	if release.CreatedAt.Before(f.MinTime) {
 		return nil, ErrNoUpgrade
 	}
+	f.Release = release.Tag
 
 	// accumulate all assets from the json into a slice
 	assets := make([]string, 0, len(release.Assets))
@@ -121,8 +136,8 @@ func (f *GithubAssetFinder) FindMatch() ([]string, error) {
 	tag := f.Tag[len("tags/"):]
 
 	for page := 1; ; page++ {
-		url := fmt.Sprintf("https://api.github.com/repos/%s/releases?page=%d", f.Repo, page)
-		resp, err := Get(url)
+		url := fmt.Sprintf("https://api.github.com/repos/%s/releases?per_page=100&page=%d", f.Repo, page)
+		resp, err := GetAPI(url)
 		if err != nil {
 			return nil, err
 		}
@@ -160,6 +175,7 @@ func (f *GithubAssetFinder) FindMatch() ([]string, error) {
 			}
 			if strings.Contains(r.Tag, tag) && !r.CreatedAt.Before(f.MinTime) {
 				// we have a winner
+				f.Release = r.Tag
 				assets := make([]string, 0, len(r.Assets))
 				for _, a := range r.Assets {
 					assets = append(assets, a.DownloadURL)
@@ -168,7 +184,7 @@ func (f *GithubAssetFinder) FindMatch() ([]string, error) {
 			}
 		}
 
-		if len(releases) < 30 {
+		if len(releases) < 100 {
 			break
 		}
 	}
@@ -178,8 +194,8 @@ func (f *GithubAssetFinder) FindMatch() ([]string, error) {
 
 // finds the latest pre-release and returns the tag
 func (f *GithubAssetFinder) getLatestTag() (string, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/releases", f.Repo)
-	resp, err := Get(url)
+	url := fmt.Sprintf("https://api.github.com/repos/%s/releases?per_page=1", f.Repo)
+	resp, err := GetAPI(url)
 	if err != nil {
 		return "", fmt.Errorf("pre-release finder: %w", err)
 	}
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"crypto/x509"
+	"time"
+)
+
 type Flags struct {
 	Tag         string
 	Prerelease  bool
@@ -15,7 +20,38 @@ type Flags struct {
 	Hash        bool
 	Verify      string
 	Remove      bool
+	DryRun      bool
 	DisableSSL  bool
+	AllowSetuid bool
+	Rollback    bool
+	Versioned   bool
+	ListVers    bool
+	Use         string
+	Prune       bool
+	Channel     string
+	FullInstall bool
+	ExtraDirs   ExtraDirs
+	PreInstall  []string
+	PostInstall []string
+	HookFailure string
+	NoHooks     bool
+	NoCache     bool
+	CacheLimit  int64
+	CacheMaxAge time.Duration
+	APICacheTTL time.Duration
+	Retries     int
+	RateWait    time.Duration
+	Segments    int
+	Rewrites    []Rewrite
+	Hosts       map[string]HostAuth
+	Netrc       map[string]HostAuth
+	RootCAs     *x509.CertPool
+	MinTLS      uint16
+
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	Timeout        time.Duration
+	NoGraphQL      bool
 }
 
 type CliFlags struct {
@@ -33,9 +69,27 @@ type CliFlags struct {
 	Hash        *bool     `long:"sha256" description:"show the SHA-256 hash of the downloaded asset"`
 	Verify      *string   `long:"verify-sha256" description:"verify the downloaded asset checksum against the one provided"`
 	Rate        bool      `long:"rate" description:"show GitHub API rate limiting information"`
-	Remove      *bool     `short:"r" long:"remove" description:"remove the given file from $EGET_BIN or the current directory"`
+	Remove      *bool     `short:"r" long:"remove" description:"remove everything installed for the given repo or file, or the given file from $EGET_BIN or the current directory"`
+	DryRun      *bool     `long:"dry-run" description:"show what --remove would delete without deleting anything"`
 	Version     bool      `short:"v" long:"version" description:"show version information"`
 	Help        bool      `short:"h" long:"help" description:"show this help message"`
 	DownloadAll bool      `short:"D" long:"download-all" description:"download all projects defined in the config file"`
 	DisableSSL  *bool     `short:"k" long:"disable-ssl" description:"disable SSL verification for download requests"`
+	AllowSetuid *bool     `long:"allow-setuid" description:"extract files with the setuid or setgid bit instead of refusing"`
+	Rollback    *bool     `long:"rollback" description:"restore the previous version of the given installed tool"`
+	Versioned   *bool     `long:"versioned" description:"install into a directory for the release and link to it, keeping other versions"`
+	ListVers    *bool     `long:"list-versions" description:"list the installed versions of the given repo"`
+	Use         *string   `long:"use" description:"switch the given repo to an installed version"`
+	Prune       *bool     `long:"prune" description:"remove the installed versions of the given repo that are not in use"`
+	FullInstall *bool     `long:"full-install" description:"also install the man pages and shell completions in the archive"`
+	NoHooks     *bool     `long:"no-hooks" description:"do not run the pre_install and post_install hooks from the config file"`
+	NoCache     *bool     `long:"no-cache" description:"do not use cached downloads or GitHub API responses"`
+	CleanCache  bool      `long:"clean-cache" description:"remove all downloads from the download cache"`
+	CacheStats  bool      `long:"cache-stats" description:"show information about the download cache"`
+	SelfUpdate  bool      `long:"self-update" description:"update eget to the latest release"`
+	Channel     *string   `long:"update-channel" description:"releases to use for --self-update: stable, pre-release, or a version such as 1.3"`
+	Retries     *int      `long:"retries" description:"number of times to retry failed requests (default: 3)"`
+	Segments    *int      `long:"segments" description:"download large assets in this many parts concurrently (default: 1)"`
+	CACerts     *[]string `long:"ca-cert" description:"trust the CA certificates in the given PEM file or directory; can be specified multiple times"`
+	MinTLS      *string   `long:"min-tls" description:"minimum TLS version to use: 1.0, 1.1, 1.2 or 1.3"`
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var graphQLURL = "https://api.github.com/graphql"
+
+const releaseFragment = `
+fragment release on Release {
+  tagName
+  isPrerelease
+  isDraft
+  createdAt
+  releaseAssets(first: 100) {
+    nodes { downloadUrl }
+    pageInfo { hasNextPage endCursor }
+  }
+}`
+
+// the latest release, as with the /releases/latest REST endpoint
+const latestQuery = `
+query($owner: String!, $name: String!) {
+  repository(owner: $owner, name: $name) {
+    latestRelease { ...release }
+  }
+}` + releaseFragment
+
+// the most recent releases, including pre-releases
+const newestQuery = `
+query($owner: String!, $name: String!) {
+  repository(owner: $owner, name: $name) {
+    releases(first: 10, orderBy: {field: CREATED_AT, direction: DESC}) {
+      nodes { ...release }
+    }
+  }
+}` + releaseFragment
+
+// the release with a tag, and a page of releases to search for a partial
+// match of the tag if there is none
+const tagQuery = `
+query($owner: String!, $name: String!, $tag: String!, $cursor: String) {
+  repository(owner: $owner, name: $name) {
+    release(tagName: $tag) { ...release }
+    releases(first: 100, after: $cursor, orderBy: {field: CREATED_AT, direction: DESC}) {
+      nodes { tagName isPrerelease isDraft createdAt }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+}` + releaseFragment
+
+// the release with a tag
+const releaseQuery = `
+query($owner: String!, $name: String!, $tag: String!) {
+  repository(owner: $owner, name: $name) {
+    release(tagName: $tag) { ...release }
+  }
+}` + releaseFragment
+
+// a page of the assets of a release
+const assetsQuery = `
+query($owner: String!, $name: String!, $tag: String!, $cursor: String) {
+  repository(owner: $owner, name: $name) {
+    release(tagName: $tag) {
+      releaseAssets(first: 100, after: $cursor) {
+        nodes { downloadUrl }
+        pageInfo { hasNextPage endCursor }
+      }
+    }
+  }
+}`
+
+type pageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type graphQLAssets struct {
+	Nodes []struct {
+		DownloadURL string `json:"downloadUrl"`
+	} `json:"nodes"`
+	PageInfo pageInfo `json:"pageInfo"`
+}
+
+type graphQLRelease struct {
+	TagName       string        `json:"tagName"`
+	IsPrerelease  bool          `json:"isPrerelease"`
+	IsDraft       bool          `json:"isDraft"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	ReleaseAssets graphQLAssets `json:"releaseAssets"`
+}
+
+// A GraphQLError is an error from the GitHub GraphQL API, after which the
+// REST API is used instead.
+type GraphQLError struct {
+	Err error
+}
+
+func (e *GraphQLError) Error() string {
+	return fmt.Sprintf("GitHub GraphQL API: %v", e.Err)
+}
+
+func (e *GraphQLError) Unwrap() error {
+	return e.Err
+}
+
+// postGraphQL sends a GraphQL request, and returns the body of the response.
+func postGraphQL(req *http.Request) ([]byte, error) {
+	resp, err := doMirrors(req)
+	if err != nil {
+		return nil, &GraphQLError{err}
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &GraphQLError{err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &GraphQLError{&GithubError{
+			Status: resp.Status,
+			Code:   resp.StatusCode,
+			Body:   b,
+			Url:    graphQLURL,
+		}}
+	}
+	return b, nil
+}
+
+// graphQL runs a query on the GitHub GraphQL API, and decodes the data of
+// the response into 'data'. Responses are stored in the API cache, keyed by
+// the query and its variables. They can't be revalidated, since the GraphQL
+// API does not support conditional requests, so like the responses of the
+// REST API, they are only used if the API cannot be reached or refuses the
+// request, within the TTL.
+func graphQL(query string, vars map[string]interface{}, data interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": vars,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", graphQLURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = SetAuthHeader(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	key := fmt.Sprintf("%s#%x", graphQLURL, sha256.Sum256(body))
+	var cached *apiResponse
+	if !opts.NoCache {
+		cached = loadAPIResponse(key)
+	}
+	b, err := postGraphQL(req)
+	fetched := err == nil
+	if err != nil {
+		if cached == nil || !cached.fresh() || !unavailable(err) {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v; using the response cached at %s\n", err, cached.Time.Format(time.RFC1123))
+		b = cached.Body
+	}
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return &GraphQLError{err}
+	}
+	if len(result.Errors) > 0 {
+		msgs := make([]string, 0, len(result.Errors))
+		for _, e := range result.Errors {
+			msgs = append(msgs, strings.TrimSuffix(e.Message, "."))
+		}
+		return &GraphQLError{fmt.Errorf("%s", strings.Join(msgs, "; "))}
+	}
+	if err := json.Unmarshal(result.Data, data); err != nil {
+		return &GraphQLError{err}
+	}
+	if fetched && !opts.NoCache {
+		r := &apiResponse{URL: key, Time: time.Now(), Body: b}
+		if err := r.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not cache API response:", err)
+		}
+	}
+	return nil
+}
+
+// findGraphQL finds the assets like Find, with queries to the GraphQL API,
+// which return the release along with its assets, and 100 releases at a time
+// when searching for a tag.
+func (f *GithubAssetFinder) findGraphQL() ([]string, error) {
+	owner, name, _ := Cut(f.Repo, "/")
+	vars := map[string]interface{}{
+		"owner": owner,
+		"name":  name,
+	}
+
+	var release *graphQLRelease
+	switch {
+	case f.Tag == "latest" && !f.Prerelease:
+		var data struct {
+			Repository struct {
+				LatestRelease *graphQLRelease `json:"latestRelease"`
+			} `json:"repository"`
+		}
+		if err := graphQL(latestQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		release = data.Repository.LatestRelease
+		if release == nil {
+			return nil, fmt.Errorf("no releases found")
+		}
+	case f.Tag == "latest":
+		var data struct {
+			Repository struct {
+				Releases struct {
+					Nodes []*graphQLRelease `json:"nodes"`
+				} `json:"releases"`
+			} `json:"repository"`
+		}
+		if err := graphQL(newestQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		for _, r := range data.Repository.Releases.Nodes {
+			if !r.IsDraft {
+				release = r
+				break
+			}
+		}
+		if release == nil {
+			return nil, fmt.Errorf("no releases found")
+		}
+	default:
+		r, err := f.findTagGraphQL(vars, strings.TrimPrefix(f.Tag, "tags/"))
+		if err != nil {
+			return nil, err
+		}
+		release = r
+	}
+
+	if release.CreatedAt.Before(f.MinTime) {
+		return nil, ErrNoUpgrade
+	}
+	f.Release = release.TagName
+
+	assets := make([]string, 0, len(release.ReleaseAssets.Nodes))
+	page := release.ReleaseAssets
+	for {
+		for _, a := range page.Nodes {
+			assets = append(assets, a.DownloadURL)
+		}
+		if !page.PageInfo.HasNextPage {
+			break
+		}
+		vars["tag"] = release.TagName
+		vars["cursor"] = page.PageInfo.EndCursor
+		var data struct {
+			Repository struct {
+				Release struct {
+					ReleaseAssets graphQLAssets `json:"releaseAssets"`
+				} `json:"release"`
+			} `json:"repository"`
+		}
+		if err := graphQL(assetsQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		page = data.Repository.Release.ReleaseAssets
+	}
+	return assets, nil
+}
+
+// findTagGraphQL returns the release with 'tag', or else the most recent
+// release whose tag contains it, like FindMatch. The assets of a partial
+// match are not in the list of releases, so they are queried after it is
+// found.
+func (f *GithubAssetFinder) findTagGraphQL(vars map[string]interface{}, tag string) (*graphQLRelease, error) {
+	vars["tag"] = tag
+	for {
+		var data struct {
+			Repository struct {
+				Release  *graphQLRelease `json:"release"`
+				Releases struct {
+					Nodes    []graphQLRelease `json:"nodes"`
+					PageInfo pageInfo         `json:"pageInfo"`
+				} `json:"releases"`
+			} `json:"repository"`
+		}
+		if err := graphQL(tagQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		if data.Repository.Release != nil {
+			return data.Repository.Release, nil
+		}
+
+		for _, r := range data.Repository.Releases.Nodes {
+			if r.IsDraft || (!f.Prerelease && r.IsPrerelease) {
+				continue
+			}
+			if strings.Contains(r.TagName, tag) && !r.CreatedAt.Before(f.MinTime) {
+				vars["tag"] = r.TagName
+				delete(vars, "cursor")
+				var match struct {
+					Repository struct {
+						Release *graphQLRelease `json:"release"`
+					} `json:"repository"`
+				}
+				if err := graphQL(releaseQuery, vars, &match); err != nil {
+					return nil, err
+				}
+				if match.Repository.Release == nil {
+					return nil, &GraphQLError{fmt.Errorf("release %s not found", r.TagName)}
+				}
+				return match.Repository.Release, nil
+			}
+		}
+
+		page := data.Repository.Releases.PageInfo
+		if !page.HasNextPage {
+			return nil, fmt.Errorf("no matching tag for '%s'", tag)
+		}
+		vars["cursor"] = page.EndCursor
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// graphQLServer answers GraphQL queries with a latest release, until its
+// status is set to an error.
+func graphQLServer(t *testing.T, status *int32) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := int(atomic.LoadInt32(status)); code != http.StatusOK {
+			http.Error(w, "error", code)
+			return
+		}
+		w.Write([]byte(`{"data": {"repository": {"latestRelease": {"tagName": "v1.0.0",
+			"releaseAssets": {"nodes": [{"downloadUrl": "https://example.com/tool.tar.gz"}]}}}}}`))
+	}))
+	t.Cleanup(srv.Close)
+	saved := graphQLURL
+	graphQLURL = srv.URL
+	t.Cleanup(func() { graphQLURL = saved })
+}
+
+func findLatest(t *testing.T) ([]string, error) {
+	t.Helper()
+	f := &GithubAssetFinder{Repo: "user/tool", Tag: "latest"}
+	assets, err := f.findGraphQL()
+	if err == nil && f.Release != "v1.0.0" {
+		t.Errorf("release = %q", f.Release)
+	}
+	return assets, err
+}
+
+func TestGraphQLCache(t *testing.T) {
+	setOpts(t, Flags{APICacheTTL: time.Hour})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	status := int32(http.StatusOK)
+	graphQLServer(t, &status)
+
+	if _, err := findLatest(t); err != nil {
+		t.Fatal(err)
+	}
+	// the cached response is used if the API refuses the request
+	for _, code := range []int32{http.StatusForbidden, http.StatusBadGateway} {
+		atomic.StoreInt32(&status, code)
+		assets, err := findLatest(t)
+		if err != nil || len(assets) != 1 {
+			t.Errorf("%d: assets %v, %v", code, assets, err)
+		}
+	}
+	// but not if the request itself is invalid
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	if _, err := findLatest(t); err == nil {
+		t.Error("used the cached response for a 404")
+	}
+
+	// or if it is older than the TTL
+	opts.APICacheTTL = 0
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	if _, err := findLatest(t); err == nil {
+		t.Error("used a cached response without a TTL")
+	}
+}
+
+func TestGraphQLNoCache(t *testing.T) {
+	setOpts(t, Flags{APICacheTTL: time.Hour, NoCache: true})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	status := int32(http.StatusOK)
+	graphQLServer(t, &status)
+
+	if _, err := findLatest(t); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	if _, err := findLatest(t); err == nil {
+		t.Error("used a cached response with --no-cache")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An UnsafeEntry is an archive entry that eget refuses to extract.
+type UnsafeEntry struct {
+	Name   string
+	Reason string
+}
+
+// An UnsafeError lists the unsafe entries found in the files being extracted.
+// Nothing is written if any entry is unsafe.
+type UnsafeError struct {
+	Entries []UnsafeEntry
+}
+
+func (e *UnsafeError) Error() string {
+	var b strings.Builder
+	b.WriteString("refusing to extract unsafe archive entries:")
+	for _, u := range e.Entries {
+		fmt.Fprintf(&b, "\n  %s: %s", u.Name, u.Reason)
+	}
+	return b.String()
+}
+
+// cleanPath converts an archive path to slash-separated form and returns
+// whether it stays within the directory it is relative to.
+func cleanPath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if isAbs(name) {
+		return name, false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return name, false
+		}
+	}
+	return name, true
+}
+
+func isAbs(name string) bool {
+	return path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != ""
+}
+
+// safeJoin joins the archive path 'name' onto the directory 'root', and
+// returns an error if the result would be outside of 'root'.
+func safeJoin(root, name string) (string, error) {
+	name, ok := cleanPath(name)
+	if !ok {
+		return "", fmt.Errorf("%s is outside of the destination", name)
+	}
+	return filepath.Join(root, filepath.FromSlash(name)), nil
+}
+
+// An entryChecker checks the entries of a file or directory being extracted
+// from an archive. The entries of a directory are checked relative to the
+// directory, since that is where they will be written.
+type entryChecker struct {
+	root        string // name of the file or directory in the archive
+	allowSetuid bool
+	symlinks    map[string]string // symlink targets by relative path
+	bad         []UnsafeEntry
+}
+
+func newEntryChecker(root string, allowSetuid bool) *entryChecker {
+	return &entryChecker{
+		root:        root,
+		allowSetuid: allowSetuid,
+		symlinks:    make(map[string]string),
+	}
+}
+
+func (c *entryChecker) reject(f File, format string, a ...interface{}) {
+	c.bad = append(c.bad, UnsafeEntry{
+		Name:   f.Name,
+		Reason: fmt.Sprintf(format, a...),
+	})
+}
+
+// check checks a single entry within the root.
+func (c *entryChecker) check(f File) {
+	if f.Type == TypeDevice {
+		c.reject(f, "device nodes and named pipes are not extracted")
+	}
+	if f.Mode&(fs.ModeSetuid|fs.ModeSetgid) != 0 && !c.allowSetuid {
+		c.reject(f, "has the setuid or setgid bit (use --allow-setuid to extract it)")
+	}
+	if f.Name == c.root {
+		// only the contents of a single file are written
+		return
+	}
+
+	rel, ok := cleanPath(strings.TrimPrefix(f.Name, c.root))
+	if !ok {
+		c.reject(f, "path is outside of the destination")
+		return
+	}
+
+	switch f.Type {
+	case TypeSymlink:
+		if isAbs(f.LinkName) {
+			c.reject(f, "symlink to absolute path %s", f.LinkName)
+			return
+		}
+		// the target is not cleaned, since that would remove '..' after
+		// another symlink
+		target := f.LinkName
+		resolved := path.Join(path.Dir(strings.TrimSuffix(rel, "/")), target)
+		if resolved == ".." || strings.HasPrefix(resolved, "../") {
+			c.reject(f, "symlink to %s points outside of the destination", f.LinkName)
+			return
+		}
+		c.symlinks[strings.TrimSuffix(rel, "/")] = target
+	case TypeLink:
+		if !strings.HasPrefix(f.LinkName, c.root) || f.LinkName == c.root {
+			c.reject(f, "hard link to %s, which is not being extracted", f.LinkName)
+			return
+		}
+		if _, ok := cleanPath(strings.TrimPrefix(f.LinkName, c.root)); !ok {
+			c.reject(f, "hard link to %s points outside of the destination", f.LinkName)
+		}
+	}
+}
+
+// throughSymlink returns true if resolving 'target' relative to the
+// directory 'dir' goes up from a directory that is a symlink in the archive,
+// since the parent of a symlink is not the parent of what it points to.
+func (c *entryChecker) throughSymlink(dir, target string) bool {
+	cur := dir
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			for p := cur; p != "." && p != "/"; p = path.Dir(p) {
+				if _, ok := c.symlinks[p]; ok {
+					return true
+				}
+			}
+			cur = path.Dir(cur)
+		default:
+			cur = path.Join(cur, part)
+		}
+	}
+	return false
+}
+
+// err returns an error listing the unsafe entries, or nil if all entries are
+// safe.
+func (c *entryChecker) err() error {
+	bad := c.bad
+	names := make([]string, 0, len(c.symlinks))
+	for name := range c.symlinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		target := c.symlinks[name]
+		if c.throughSymlink(path.Dir(name), target) {
+			bad = append(bad, UnsafeEntry{
+				Name:   c.root + name,
+				Reason: fmt.Sprintf("symlink to %s passes through another symlink", target),
+			})
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	return &UnsafeError{
+		Entries: bad,
+	}
+}
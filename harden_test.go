@@ -0,0 +1,244 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	root := filepath.FromSlash("/dest")
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"bin/tool", true},
+		{"a/b/../c", false},
+		{"../tool", false},
+		{"bin/../../tool", false},
+		{"/etc/passwd", false},
+		{`..\tool`, false},
+		{"..tool/x", true},
+	}
+	for _, tt := range tests {
+		_, err := safeJoin(root, tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("safeJoin(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestEntryChecker(t *testing.T) {
+	tests := []struct {
+		name        string
+		root        string
+		files       []File
+		allowSetuid bool
+		bad         []string // names of the rejected entries
+	}{
+		{
+			name: "safe directory",
+			root: "tool/",
+			files: []File{
+				{Name: "tool/", Type: TypeDir},
+				{Name: "tool/bin/tool", Type: TypeNormal, Mode: 0755},
+				{Name: "tool/bin/alias", Type: TypeSymlink, LinkName: "tool"},
+				{Name: "tool/lib/current", Type: TypeSymlink, LinkName: "../bin"},
+				{Name: "tool/bin/hard", Type: TypeLink, LinkName: "tool/bin/tool"},
+			},
+		},
+		{
+			name: "parent directory",
+			root: "tool/",
+			files: []File{
+				{Name: "tool/../evil", Type: TypeNormal},
+				{Name: "tool/bin/../../../evil", Type: TypeNormal},
+			},
+			bad: []string{"tool/../evil", "tool/bin/../../../evil"},
+		},
+		{
+			name:  "absolute path",
+			root:  "",
+			files: []File{{Name: "/etc/passwd", Type: TypeNormal}},
+			bad:   []string{"/etc/passwd"},
+		},
+		{
+			name: "escaping symlinks",
+			root: "tool/",
+			files: []File{
+				{Name: "tool/abs", Type: TypeSymlink, LinkName: "/etc/passwd"},
+				{Name: "tool/up", Type: TypeSymlink, LinkName: "../../etc"},
+				{Name: "tool/bin/up", Type: TypeSymlink, LinkName: "../.."},
+			},
+			bad: []string{"tool/abs", "tool/up", "tool/bin/up"},
+		},
+		{
+			name: "symlink through symlink",
+			root: "tool/",
+			files: []File{
+				{Name: "tool/a", Type: TypeSymlink, LinkName: "b/c"},
+				{Name: "tool/a/x", Type: TypeSymlink, LinkName: "../../.."},
+			},
+			bad: []string{"tool/a/x"},
+		},
+		{
+			name: "hard links",
+			root: "tool/",
+			files: []File{
+				{Name: "tool/outside", Type: TypeLink, LinkName: "other/file"},
+				{Name: "tool/up", Type: TypeLink, LinkName: "tool/../../etc/passwd"},
+			},
+			bad: []string{"tool/outside", "tool/up"},
+		},
+		{
+			name: "devices",
+			root: "tool/",
+			files: []File{
+				{Name: "tool/null", Type: TypeDevice},
+			},
+			bad: []string{"tool/null"},
+		},
+		{
+			name: "setuid",
+			root: "tool/",
+			files: []File{
+				{Name: "tool/su", Type: TypeNormal, Mode: 0755 | fs.ModeSetuid},
+				{Name: "tool/sg", Type: TypeNormal, Mode: 0755 | fs.ModeSetgid},
+			},
+			bad: []string{"tool/su", "tool/sg"},
+		},
+		{
+			name:        "allowed setuid",
+			root:        "tool/",
+			allowSetuid: true,
+			files: []File{
+				{Name: "tool/su", Type: TypeNormal, Mode: 0755 | fs.ModeSetuid},
+			},
+		},
+		{
+			name:  "single setuid file",
+			root:  "su",
+			files: []File{{Name: "su", Type: TypeNormal, Mode: 0755 | fs.ModeSetuid}},
+			bad:   []string{"su"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newEntryChecker(tt.root, tt.allowSetuid)
+			for _, f := range tt.files {
+				c.check(f)
+			}
+			err := c.err()
+			if len(tt.bad) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var ue *UnsafeError
+			if !errors.As(err, &ue) {
+				t.Fatalf("err = %v, want an UnsafeError", err)
+			}
+			var got []string
+			for _, e := range ue.Entries {
+				got = append(got, e.Name)
+			}
+			if len(got) != len(tt.bad) {
+				t.Fatalf("rejected %v, want %v", got, tt.bad)
+			}
+			for i := range got {
+				if got[i] != tt.bad[i] {
+					t.Errorf("rejected %v, want %v", got, tt.bad)
+				}
+			}
+		})
+	}
+}
+
+// extractTar extracts the file or directory chosen by 'glob' from a tar
+// archive with 'entries' to a new directory, and returns the directory.
+func extractTar(t *testing.T, glob string, allowSetuid bool, entries []tarEntry) (string, error) {
+	t.Helper()
+	data := makeTar(t, entries)
+	gc, err := NewGlobChooser(glob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &ArchiveExtractor{
+		File:        gc,
+		Ar:          NewTarArchive,
+		Decompress:  nounzipper,
+		AllowSetuid: allowSetuid,
+	}
+	bin, _, err := e.Extract(bytes.NewReader(data), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	return dir, bin.Extract(filepath.Join(dir, "out"))
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		glob    string
+		entries []tarEntry
+	}{
+		{"parent directory", "tool", []tarEntry{
+			{name: "tool/", typ: tar.TypeDir, mode: 0755},
+			{name: "tool/bin", typ: tar.TypeReg, mode: 0755, data: "x"},
+			{name: "tool/../../escaped", typ: tar.TypeReg, mode: 0644, data: "x"},
+		}},
+		{"escaping symlink", "tool", []tarEntry{
+			{name: "tool/", typ: tar.TypeDir, mode: 0755},
+			{name: "tool/link", typ: tar.TypeSymlink, link: "../../.."},
+			{name: "tool/bin", typ: tar.TypeReg, mode: 0755, data: "x"},
+		}},
+		{"absolute symlink", "tool", []tarEntry{
+			{name: "tool/", typ: tar.TypeDir, mode: 0755},
+			{name: "tool/link", typ: tar.TypeSymlink, link: "/etc"},
+		}},
+		{"setuid file", "tool", []tarEntry{
+			{name: "tool", typ: tar.TypeReg, mode: 04755, data: "x"},
+		}},
+		{"setuid in directory", "tool", []tarEntry{
+			{name: "tool/", typ: tar.TypeDir, mode: 0755},
+			{name: "tool/su", typ: tar.TypeReg, mode: 04755, data: "x"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := extractTar(t, tt.glob, false, tt.entries)
+			var ue *UnsafeError
+			if !errors.As(err, &ue) {
+				t.Fatalf("err = %v, want an UnsafeError", err)
+			}
+			// nothing is written
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 0 {
+				t.Errorf("%d files were written", len(entries))
+			}
+			if _, err := os.Lstat(filepath.Join(filepath.Dir(dir), "escaped")); err == nil {
+				t.Errorf("a file was written outside of the destination")
+			}
+		})
+	}
+}
+
+func TestExtractSetuidAllowed(t *testing.T) {
+	dir, err := extractTar(t, "tool", true, []tarEntry{
+		{name: "tool/", typ: tar.TypeDir, mode: 0755},
+		{name: "tool/su", typ: tar.TypeReg, mode: 04755, data: "x"},
+		{name: "tool/link", typ: tar.TypeSymlink, link: "su"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "out", "link")); err != nil || string(b) != "x" {
+		t.Errorf("link = %q, %v", b, err)
+	}
+}
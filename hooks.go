@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// What to do when a hook fails.
+const (
+	HookAbort = "abort"
+	HookWarn  = "warn"
+)
+
+// hookEnv returns the environment of hooks, which describes the install.
+// 'files' are the paths that were installed, which are only known after
+// installing.
+func hookEnv(tool, project, tag, url string, files []string) []string {
+	abs := make([]string, len(files))
+	for i, f := range files {
+		abs[i], _ = filepath.Abs(f)
+	}
+	files = abs
+	if tool == "" && len(files) > 0 {
+		// the tool is only known from the repository name
+		tool = filepath.Base(files[0])
+	}
+	env := append(os.Environ(),
+		"EGET_TOOL="+tool,
+		"EGET_REPO="+project,
+		"EGET_TAG="+tag,
+		"EGET_ASSET_URL="+url,
+		"EGET_FILES="+strings.Join(files, string(os.PathListSeparator)),
+	)
+	if len(files) > 0 {
+		env = append(env, "EGET_FILE="+files[0])
+	}
+	return env
+}
+
+func hookCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// runHooks runs the shell commands of a hook, such as pre_install, in order.
+// If a command fails, the remaining commands are not run, and an error is
+// returned if 'failure' is HookAbort, or a warning is printed if it is
+// HookWarn.
+func runHooks(name string, commands []string, env []string, failure string, output io.Writer) error {
+	for _, command := range commands {
+		fmt.Fprintf(output, "Running %s hook `%s`\n", name, command)
+		cmd := hookCommand(command)
+		cmd.Env = env
+		cmd.Stdin = os.Stdin
+		// keep stdout for output of eget itself
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			err = fmt.Errorf("%s hook `%s` failed: %w", name, command, err)
+			if failure == HookWarn {
+				fmt.Fprintln(os.Stderr, "warning:", err)
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
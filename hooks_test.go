@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// captureStderr returns what 'f' writes to stderr.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	f()
+	os.Stderr = saved
+	w.Close()
+	return <-done
+}
+
+func TestHookEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run with sh")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "env")
+	files := []string{filepath.Join(dir, "rg"), filepath.Join(dir, "rg.1")}
+	env := hookEnv("", "BurntSushi/ripgrep", "14.1.0", "https://example.com/rg.tar.gz", files)
+
+	command := `printf '%s\n' "$EGET_TOOL" "$EGET_REPO" "$EGET_TAG" "$EGET_ASSET_URL" "$EGET_FILE" "$EGET_FILES" > ` + out
+	if err := runHooks("post_install", []string{command}, env, HookAbort, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"rg", // the tool is named after the first file
+		"BurntSushi/ripgrep",
+		"14.1.0",
+		"https://example.com/rg.tar.gz",
+		files[0],
+		files[0] + string(os.PathListSeparator) + files[1],
+	}, "\n") + "\n"
+	if got := readString(t, out); got != want {
+		t.Errorf("environment:\n%s\nwant:\n%s", got, want)
+	}
+
+	// before installing, there are no files
+	env = hookEnv("rg", "BurntSushi/ripgrep", "14.1.0", "https://example.com/rg.tar.gz", nil)
+	for _, v := range env {
+		if strings.HasPrefix(v, "EGET_FILE=") {
+			t.Errorf("pre_install environment has %s", v)
+		}
+	}
+}
+
+func TestHookFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run with sh")
+	}
+	tests := []struct {
+		name    string
+		failure string
+		err     bool
+	}{
+		{"pre_install", HookAbort, true},
+		{"post_install", HookAbort, true},
+		{"pre_install", HookWarn, false},
+		{"post_install", HookWarn, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.failure, func(t *testing.T) {
+			after := filepath.Join(t.TempDir(), "after")
+			commands := []string{"true", "exit 3", "touch " + after}
+			var output bytes.Buffer
+			var err error
+			stderr := captureStderr(t, func() {
+				err = runHooks(tt.name, commands, os.Environ(), tt.failure, &output)
+			})
+
+			msg := tt.name + " hook `exit 3` failed: exit status 3"
+			if tt.err {
+				if err == nil || err.Error() != msg {
+					t.Errorf("err = %v, want %q", err, msg)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("err = %v, want only a warning", err)
+				}
+				if !strings.Contains(stderr, "warning: "+msg) {
+					t.Errorf("stderr = %q, want a warning", stderr)
+				}
+			}
+			// the commands after the one that failed are not run
+			if exists(after) {
+				t.Error("a command after the failed one was run")
+			}
+			if want := "Running " + tt.name + " hook `true`\nRunning " + tt.name + " hook `exit 3`\n"; output.String() != want {
+				t.Errorf("output = %q, want %q", output.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/zyedidia/eget/home"
+)
+
+// HostAuth is the authentication for requests to a host: basic auth with a
+// username and password, a bearer token, extra headers, and a TLS client
+// certificate.
+type HostAuth struct {
+	Username   string
+	Password   string
+	Token      string
+	Headers    map[string]string
+	ClientCert *tls.Certificate
+}
+
+// secretFrom returns a credential of a [hosts."name"] table, which is read
+// from an environment variable if it is "env:NAME", or from a file if it is
+// "@file".
+func secretFrom(s string) (string, error) {
+	if strings.HasPrefix(s, "env:") {
+		v, ok := os.LookupEnv(s[len("env:"):])
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", s[len("env:"):])
+		}
+		return v, nil
+	}
+	return tokenFrom(s)
+}
+
+// NewHostAuth returns the authentication for a [hosts."name"] table. The
+// password, token and header values may be read from a file with "@file" or
+// from an environment variable with "env:NAME".
+func NewHostAuth(host string, conf ConfigHost) (HostAuth, error) {
+	auth := HostAuth{
+		Username: conf.Username,
+		Headers:  make(map[string]string),
+	}
+	if conf.Password != "" && conf.Token != "" {
+		return auth, fmt.Errorf("host %s: cannot use both a password and a token", host)
+	}
+	var err error
+	if auth.Password, err = secretFrom(conf.Password); err != nil {
+		return auth, fmt.Errorf("host %s: password: %w", host, err)
+	}
+	if auth.Token, err = secretFrom(conf.Token); err != nil {
+		return auth, fmt.Errorf("host %s: token: %w", host, err)
+	}
+	for name, value := range conf.Headers {
+		if auth.Headers[name], err = secretFrom(value); err != nil {
+			return auth, fmt.Errorf("host %s: header %s: %w", host, name, err)
+		}
+	}
+	if conf.ClientCert != "" {
+		if auth.ClientCert, err = loadClientCert(conf.ClientCert, conf.ClientKey); err != nil {
+			return auth, fmt.Errorf("host %s: %w", host, err)
+		}
+	} else if conf.ClientKey != "" {
+		return auth, fmt.Errorf("host %s: client_key requires a client_cert", host)
+	}
+	return auth, nil
+}
+
+// sendsSecrets returns true if requests to the host include a password, token
+// or headers.
+func (a HostAuth) sendsSecrets() bool {
+	return a.Username != "" || a.Password != "" || a.Token != "" || len(a.Headers) > 0
+}
+
+func (a HostAuth) apply(req *http.Request) {
+	switch {
+	case a.Token != "":
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	case a.Username != "" || a.Password != "":
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+	for name, value := range a.Headers {
+		req.Header.Set(name, value)
+	}
+}
+
+// netrcPath returns the location of the netrc file, which is $NETRC or
+// ~/.netrc (~/_netrc on Windows).
+func netrcPath() (string, error) {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p, nil
+	}
+	dir, err := home.Home()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(dir, "_netrc"), nil
+	}
+	return filepath.Join(dir, ".netrc"), nil
+}
+
+// readNetrc returns the logins in a netrc file by machine name. The
+// 'default' entry is ignored, so that credentials are only sent to the hosts
+// they are for.
+func readNetrc(path string) (map[string]HostAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	logins := make(map[string]HostAuth)
+	var machine string
+	var auth HostAuth
+	inMachine := false
+	done := func() {
+		if _, ok := logins[machine]; inMachine && !ok {
+			// the first entry for a machine is used
+			logins[machine] = auth
+		}
+	}
+
+	scanner := bufio.NewScanner(f)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro definition ends with an empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			if strings.HasPrefix(fields[i], "#") {
+				break
+			}
+			value := func() string {
+				if i+1 < len(fields) {
+					i++
+					return fields[i]
+				}
+				return ""
+			}
+			switch fields[i] {
+			case "machine":
+				done()
+				machine, auth, inMachine = value(), HostAuth{}, true
+			case "default":
+				done()
+				inMachine = false
+			case "login":
+				auth.Username = value()
+			case "password":
+				auth.Password = value()
+			case "account":
+				value()
+			case "macdef":
+				done()
+				inMachine, inMacro = false, true
+				i = len(fields)
+			}
+		}
+	}
+	done()
+	return logins, scanner.Err()
+}
+
+// loadNetrc returns the logins in the netrc file, or none if it does not
+// exist.
+func loadNetrc() (map[string]HostAuth, error) {
+	p, err := netrcPath()
+	if err != nil {
+		return nil, err
+	}
+	logins, err := readNetrc(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return logins, nil
+}
+
+// hostAuth returns the authentication for the host of 'req': the [hosts]
+// table of the host (with or without its port), or else its netrc login.
+func hostAuth(req *http.Request) (HostAuth, bool) {
+	for _, host := range []string{req.URL.Host, req.URL.Hostname()} {
+		if auth, ok := opts.Hosts[host]; ok {
+			return auth, true
+		}
+	}
+	auth, ok := opts.Netrc[req.URL.Hostname()]
+	return auth, ok
+}
+
+// An authTransport adds the authentication of each host to requests to it.
+// It is applied to every request, including redirects, so credentials are
+// never sent to a different host that a request is redirected to.
+type authTransport struct {
+	base http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	auth, ok := hostAuth(req)
+	if !ok || !auth.sendsSecrets() {
+		return t.base.RoundTrip(req)
+	}
+	if opts.DisableSSL && req.URL.Scheme == "https" {
+		return nil, fmt.Errorf("cannot use credentials for %s if SSL verification is disabled", req.URL.Host)
+	}
+	req = req.Clone(req.Context())
+	if req.Header.Get("Authorization") != "" {
+		// the GitHub token is used instead
+		auth.Username, auth.Password, auth.Token = "", "", ""
+	}
+	auth.apply(req)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretFrom(t *testing.T) {
+	t.Setenv("EGET_TEST_SECRET", "from env")
+	file := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(file, []byte("from file\n"), 0600)
+
+	tests := []struct {
+		in, want string
+	}{
+		{"env:EGET_TEST_SECRET", "from env"},
+		{"@" + file, "from file"},
+		{"plain", "plain"},
+		// only the explicit form reads the environment
+		{"$EGET_TEST_SECRET", "$EGET_TEST_SECRET"},
+	}
+	for _, tt := range tests {
+		got, err := secretFrom(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("secretFrom(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := secretFrom("env:EGET_TEST_UNSET"); err == nil {
+		t.Error("an unset variable did not fail")
+	}
+}
+
+func TestGithubTokenFrom(t *testing.T) {
+	t.Setenv("EGET_TEST_SECRET", "from env")
+	// a GitHub token is used as it is, even if it looks like a variable
+	for _, token := range []string{"$EGET_TEST_SECRET", "env:EGET_TEST_SECRET"} {
+		t.Setenv("EGET_GITHUB_TOKEN", token)
+		if got, err := getGithubToken(); err != nil || got != token {
+			t.Errorf("token %q = %q, %v", token, got, err)
+		}
+	}
+}
+
+func TestNewHostAuth(t *testing.T) {
+	t.Setenv("EGET_TEST_SECRET", "s3cret")
+	auth, err := NewHostAuth("example.com", ConfigHost{
+		Token:   "env:EGET_TEST_SECRET",
+		Headers: map[string]string{"X-Key": "env:EGET_TEST_SECRET"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Token != "s3cret" || auth.Headers["X-Key"] != "s3cret" {
+		t.Errorf("auth = %+v", auth)
+	}
+	if _, err := NewHostAuth("example.com", ConfigHost{Password: "env:EGET_TEST_UNSET"}); err == nil {
+		t.Error("an unset variable did not fail")
+	}
+}
+
+func TestLoadNetrc(t *testing.T) {
+	dir := t.TempDir()
+	netrc := filepath.Join(dir, "netrc")
+	os.WriteFile(netrc, []byte("machine example.com login user password pass\ndefault login anon password x\n"), 0600)
+	t.Setenv("NETRC", netrc)
+	logins, err := loadNetrc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := logins["example.com"]; a.Username != "user" || a.Password != "pass" || len(logins) != 1 {
+		t.Errorf("logins = %+v", logins)
+	}
+
+	t.Setenv("NETRC", filepath.Join(dir, "missing"))
+	if logins, err := loadNetrc(); err != nil || logins != nil {
+		t.Errorf("missing netrc: %v, %v", logins, err)
+	}
+	// a netrc that can't be read is an error, which the configuration only
+	// warns about
+	t.Setenv("NETRC", dir)
+	if _, err := loadNetrc(); err == nil {
+		t.Error("reading a directory did not fail")
+	}
+}
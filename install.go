@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A Stage is a temporary directory next to an install target. Files are
+// extracted into the stage and then moved into place with a rename, so the
+// target is never left partially written.
+type Stage struct {
+	Path   string // where to extract to
+	Target string
+
+	dir string
+}
+
+// NewStage creates a stage for installing to 'target'. The stage is in the
+// same directory as the target so that it is on the same filesystem.
+func NewStage(target string) (*Stage, error) {
+	parent := filepath.Dir(target)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(parent, ".eget-stage-*")
+	if err != nil {
+		return nil, err
+	}
+	atExit(func() { os.RemoveAll(dir) })
+	return &Stage{
+		Path:   filepath.Join(dir, filepath.Base(target)),
+		Target: target,
+		dir:    dir,
+	}, nil
+}
+
+// backupPath returns the path where the previous version of 'target' is kept.
+func backupPath(target string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".eget-backup")
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// isDir returns true if 'path' is a directory (and not a symlink to one).
+func isDir(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.IsDir()
+}
+
+// duplicate makes 'dst' a copy of the file or symlink 'src', so that 'src' can
+// then be replaced with a single rename. Files are hard linked if possible,
+// and copied otherwise.
+func duplicate(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	// the mode given to OpenFile is reduced by the umask
+	return os.Chmod(dst, fi.Mode().Perm())
+}
+
+// replace moves 'src' to 'dst', keeping the previous 'dst' at 'keep' if it
+// exists. Files and symlinks are duplicated to 'keep' and then replaced with a
+// rename, so 'dst' always exists. A directory can't be replaced by a rename,
+// and neither can a running program on Windows, so those are moved to 'keep'
+// first instead, and put back if the move fails.
+func replace(src, dst, keep string) error {
+	if !exists(dst) {
+		return os.Rename(src, dst)
+	}
+	if !isDir(src) && !isDir(dst) {
+		if err := duplicate(dst, keep); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err == nil {
+			return nil
+		}
+		os.Remove(keep)
+	}
+
+	if err := os.Rename(dst, keep); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		os.Rename(keep, dst)
+		return err
+	}
+	return nil
+}
+
+// Commit moves the staged file into place. If the target already exists, it
+// is kept as a backup, and the path of the backup is returned.
+func (s *Stage) Commit() (string, error) {
+	defer s.Discard()
+
+	if !exists(s.Path) {
+		return "", errors.New("nothing was extracted")
+	}
+
+	var backup string
+	if exists(s.Target) {
+		backup = backupPath(s.Target)
+		if err := os.RemoveAll(backup); err != nil {
+			return "", err
+		}
+	}
+	if err := replace(s.Path, s.Target, backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// Discard removes the stage and anything in it.
+func (s *Stage) Discard() {
+	os.RemoveAll(s.dir)
+}
+
+// Rollback swaps the target of an install with its backup, so that rolling
+// back again restores the version that was rolled back. The backup replaces
+// the target in the same way as a new version in Commit, and the files of the
+// install are recorded again in 'm', so that they can still be uninstalled.
+func Rollback(m *Manifest, in *Install) error {
+	if in.Backup == "" || !exists(in.Backup) {
+		return fmt.Errorf("no previous version of %s to roll back to", in.Target)
+	}
+
+	tmp := in.Target + ".eget-rollback"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := replace(in.Backup, in.Target, tmp); err != nil {
+		return err
+	}
+	if exists(tmp) {
+		if err := os.Rename(tmp, in.Backup); err != nil {
+			return err
+		}
+	}
+	if err := recordFiles(in); err != nil {
+		return err
+	}
+	return m.Save()
+}
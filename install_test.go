@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readString(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// stageFile stages a file with 'data' for 'target'.
+func stageFile(t *testing.T, target, data string) *Stage {
+	t.Helper()
+	s, err := NewStage(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.Path, []byte(data), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCommit(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "bin", "tool")
+
+	backup, err := stageFile(t, target, "v1").Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != "" || readString(t, target) != "v1" {
+		t.Fatalf("first install: backup %q, target %q", backup, readString(t, target))
+	}
+	old, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err = stageFile(t, target, "v2").Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != backupPath(target) {
+		t.Errorf("backup = %q, want %q", backup, backupPath(target))
+	}
+	if readString(t, target) != "v2" || readString(t, backup) != "v1" {
+		t.Errorf("target %q, backup %q", readString(t, target), readString(t, backup))
+	}
+	// the backup is the previous file itself, which was replaced by a rename
+	if fi, err := os.Stat(backup); err != nil || !os.SameFile(fi, old) {
+		t.Errorf("backup is not a link to the previous target")
+	}
+	if fi, err := os.Stat(backup); err != nil || fi.Mode().Perm() != old.Mode().Perm() {
+		t.Errorf("backup mode changed")
+	}
+
+	// no stage directories are left behind
+	entries, _ := os.ReadDir(filepath.Dir(target))
+	for _, e := range entries {
+		if e.Name() != "tool" && e.Name() != ".tool.eget-backup" {
+			t.Errorf("unexpected file %s", e.Name())
+		}
+	}
+}
+
+func TestCommitNothing(t *testing.T) {
+	s, err := NewStage(filepath.Join(t.TempDir(), "tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Commit(); err == nil {
+		t.Error("committed an empty stage")
+	}
+}
+
+func TestCommitDir(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "tool")
+	for _, version := range []string{"v1", "v2"} {
+		s, err := NewStage(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.MkdirAll(filepath.Join(s.Path, "bin"), 0755)
+		os.WriteFile(filepath.Join(s.Path, "bin", "tool"), []byte(version), 0755)
+		if _, err := s.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readString(t, filepath.Join(target, "bin", "tool")); got != "v2" {
+		t.Errorf("target = %q", got)
+	}
+	if got := readString(t, filepath.Join(backupPath(target), "bin", "tool")); got != "v1" {
+		t.Errorf("backup = %q", got)
+	}
+}
+
+func TestCommitSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "tool")
+	os.WriteFile(filepath.Join(dir, "tool-v1"), []byte("v1"), 0755)
+	if err := os.Symlink("tool-v1", target); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+	backup, err := stageFile(t, target, "v2").Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(backup); err != nil || link != "tool-v1" {
+		t.Errorf("backup links to %q, %v", link, err)
+	}
+	if readString(t, target) != "v2" || readString(t, filepath.Join(dir, "tool-v1")) != "v1" {
+		t.Error("the file the symlink pointed to was changed")
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	os.WriteFile(src, []byte("data"), 0751)
+	os.Chmod(src, 0751)
+	// an existing destination can't be linked to, and is not overwritten
+	dst := filepath.Join(dir, "dst")
+	os.WriteFile(dst, []byte("other"), 0644)
+	if err := duplicate(src, dst); err == nil {
+		t.Error("duplicate replaced an existing file")
+	}
+	os.Remove(dst)
+	if err := duplicate(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if readString(t, dst) != "data" {
+		t.Errorf("dst = %q", readString(t, dst))
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "tool")
+	stageFile(t, target, "v1").Commit()
+	backup, err := stageFile(t, target, "v2").Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &Install{Target: target, Backup: backup}
+	m := recordInstall(t, in)
+
+	if err := Rollback(m, in); err != nil {
+		t.Fatal(err)
+	}
+	if readString(t, target) != "v1" || readString(t, backup) != "v2" {
+		t.Errorf("after rollback: target %q, backup %q", readString(t, target), readString(t, backup))
+	}
+	// rolling back again restores the newer version
+	if err := Rollback(m, in); err != nil {
+		t.Fatal(err)
+	}
+	if readString(t, target) != "v2" || readString(t, backup) != "v1" {
+		t.Errorf("after second rollback: target %q, backup %q", readString(t, target), readString(t, backup))
+	}
+	if exists(target + ".eget-rollback") {
+		t.Error("temporary rollback file was left behind")
+	}
+
+	os.Remove(backup)
+	if err := Rollback(m, in); err == nil {
+		t.Error("rolled back without a backup")
+	}
+}
+
+func TestRollbackUninstall(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "tool")
+	stageFile(t, target, "v1").Commit()
+	backup, err := stageFile(t, target, "v2").Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &Install{Target: target, Backup: backup}
+	m := recordInstall(t, in)
+
+	if err := Rollback(m, in); err != nil {
+		t.Fatal(err)
+	}
+	// the manifest has the checksum of the version that was rolled back to
+	m, err = LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	installs := m.Find(target)
+	if len(installs) != 1 {
+		t.Fatalf("%d installs of %s in the manifest", len(installs), target)
+	}
+	if _, err := Uninstall(m, installs, false); err != nil {
+		t.Fatal(err)
+	}
+	if exists(target) || exists(backup) {
+		t.Error("files were left behind")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errLz4Corrupt = errors.New("lz4: corrupt block")
+
+// An lz4Reader decompresses data in the LZ4 frame format.
+type lz4Reader struct {
+	r        *bufio.Reader
+	indep    bool // blocks do not refer to previous blocks
+	blockSum bool
+	contSum  bool
+	maxBlock int
+	hist     []byte // previous output, used by linked blocks
+	out      []byte // decompressed data that has not been read yet
+	done     bool
+}
+
+func newLz4Reader(r io.Reader) (io.Reader, error) {
+	lr := &lz4Reader{
+		r: bufio.NewReader(r),
+	}
+	if err := lr.frame(); err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+// frame reads a frame header.
+func (l *lz4Reader) frame() error {
+	var magic [4]byte
+	if _, err := io.ReadFull(l.r, magic[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(magic[:]) != 0x184d2204 {
+		return errors.New("lz4: invalid frame magic")
+	}
+	var desc [2]byte
+	if _, err := io.ReadFull(l.r, desc[:]); err != nil {
+		return err
+	}
+	flg, bd := desc[0], desc[1]
+	if flg>>6 != 1 {
+		return fmt.Errorf("lz4: unsupported version %d", flg>>6)
+	}
+	l.indep = flg&0x20 != 0
+	l.blockSum = flg&0x10 != 0
+	l.contSum = flg&0x04 != 0
+
+	switch (bd >> 4) & 7 {
+	case 4:
+		l.maxBlock = 64 << 10
+	case 5:
+		l.maxBlock = 256 << 10
+	case 6:
+		l.maxBlock = 1 << 20
+	case 7:
+		l.maxBlock = 4 << 20
+	default:
+		return errors.New("lz4: invalid block size")
+	}
+
+	// skip the content size, dictionary id, and header checksum
+	skip := 1
+	if flg&0x08 != 0 {
+		skip += 8
+	}
+	if flg&0x01 != 0 {
+		skip += 4
+	}
+	_, err := l.r.Discard(skip)
+	return err
+}
+
+func (l *lz4Reader) Read(p []byte) (int, error) {
+	for len(l.out) == 0 {
+		if l.done {
+			return 0, io.EOF
+		}
+		if err := l.block(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, l.out)
+	l.out = l.out[n:]
+	return n, nil
+}
+
+func (l *lz4Reader) block() error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(l.r, hdr[:]); err != nil {
+		return unexpected(err)
+	}
+	size := binary.LittleEndian.Uint32(hdr[:])
+	if size == 0 {
+		// end of frame
+		if l.contSum {
+			if _, err := l.r.Discard(4); err != nil {
+				return unexpected(err)
+			}
+		}
+		if _, err := l.r.Peek(1); err == io.EOF {
+			l.done = true
+			return nil
+		}
+		// another frame follows
+		l.hist = nil
+		return l.frame()
+	}
+
+	raw := size&0x80000000 != 0
+	size &= 0x7fffffff
+	if int(size) > l.maxBlock {
+		return errLz4Corrupt
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(l.r, data); err != nil {
+		return unexpected(err)
+	}
+	if l.blockSum {
+		if _, err := l.r.Discard(4); err != nil {
+			return unexpected(err)
+		}
+	}
+
+	if !raw {
+		var dict []byte
+		if !l.indep {
+			dict = l.hist
+		}
+		var err error
+		data, err = lz4Block(data, dict, l.maxBlock)
+		if err != nil {
+			return err
+		}
+	}
+	l.out = data
+
+	if !l.indep {
+		// keep the last 64KB of output for references from later blocks
+		const window = 64 << 10
+		l.hist = append(l.hist, data...)
+		if len(l.hist) > window {
+			l.hist = append([]byte(nil), l.hist[len(l.hist)-window:]...)
+		}
+	}
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// lz4Block decompresses a single LZ4 block. Matches may refer back into dict,
+// which holds the data preceding the block.
+func lz4Block(src, dict []byte, max int) ([]byte, error) {
+	buf := make([]byte, len(dict), len(dict)+max)
+	copy(buf, dict)
+	start := len(dict)
+
+	length := func(i int, n int) (int, int, error) {
+		if n != 15 {
+			return i, n, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, 0, errLz4Corrupt
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+
+	i := 0
+	for i < len(src) {
+		token := src[i]
+		i++
+
+		var lit int
+		var err error
+		i, lit, err = length(i, int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+		if lit > len(src)-i || len(buf)-start+lit > max {
+			return nil, errLz4Corrupt
+		}
+		buf = append(buf, src[i:i+lit]...)
+		i += lit
+		if i == len(src) {
+			// the last sequence only has literals
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errLz4Corrupt
+		}
+		off := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		var ml int
+		i, ml, err = length(i, int(token&15))
+		if err != nil {
+			return nil, err
+		}
+		ml += 4
+		if off == 0 || off > len(buf) || len(buf)-start+ml > max {
+			return nil, errLz4Corrupt
+		}
+		// matches may overlap with the data being written
+		pos := len(buf) - off
+		for k := 0; k < ml; k++ {
+			buf = append(buf, buf[pos+k])
+		}
+	}
+	return buf[start:], nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"strings"
+	"testing"
+)
+
+// xxh32 is the checksum used in LZ4 frames, with a seed of 0.
+func xxh32(b []byte) uint32 {
+	const (
+		p1 uint32 = 2654435761
+		p2 uint32 = 2246822519
+		p3 uint32 = 3266489917
+		p4 uint32 = 668265263
+		p5 uint32 = 374761393
+	)
+	round := func(acc, v uint32) uint32 {
+		return bits.RotateLeft32(acc+v*p2, 13) * p1
+	}
+	n := uint32(len(b))
+	var h uint32
+	if len(b) >= 16 {
+		v1, v2, v3, v4 := p1, p2, uint32(0), uint32(0)
+		v1 += p2
+		v4 -= p1
+		for ; len(b) >= 16; b = b[16:] {
+			v1 = round(v1, binary.LittleEndian.Uint32(b[0:]))
+			v2 = round(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = round(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = round(v4, binary.LittleEndian.Uint32(b[12:]))
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = p5
+	}
+	h += n
+	for ; len(b) >= 4; b = b[4:] {
+		h = bits.RotateLeft32(h+binary.LittleEndian.Uint32(b)*p3, 17) * p4
+	}
+	for ; len(b) > 0; b = b[1:] {
+		h = bits.RotateLeft32(h+uint32(b[0])*p5, 11) * p1
+	}
+	h ^= h >> 15
+	h *= p2
+	h ^= h >> 13
+	h *= p3
+	h ^= h >> 16
+	return h
+}
+
+// lz4Seq encodes an LZ4 sequence of literals followed by a match of 'ml'
+// bytes at 'off' bytes back. The last sequence of a block has no match.
+func lz4Seq(lit string, off, ml int) []byte {
+	var b []byte
+	ext := func(n int) {
+		for ; n >= 255; n -= 255 {
+			b = append(b, 255)
+		}
+		b = append(b, byte(n))
+	}
+	token := byte(0)
+	if len(lit) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(lit)) << 4
+	}
+	if ml > 0 {
+		if ml-4 >= 15 {
+			token |= 15
+		} else {
+			token |= byte(ml - 4)
+		}
+	}
+	b = append(b, token)
+	if len(lit) >= 15 {
+		ext(len(lit) - 15)
+	}
+	b = append(b, lit...)
+	if ml > 0 {
+		b = append(b, byte(off), byte(off>>8))
+		if ml-4 >= 15 {
+			ext(ml - 4 - 15)
+		}
+	}
+	return b
+}
+
+type lz4Frame struct {
+	indep       bool
+	blockSum    bool
+	contentSize int64 // stored in the header if >= 0
+	contentSum  bool
+	blocks      [][]byte // compressed blocks
+	raw         []bool   // blocks that are stored uncompressed
+}
+
+// bytes encodes the frame, where 'content' is the decompressed data that is
+// used for the content size and checksum.
+func (f lz4Frame) bytes(content []byte) []byte {
+	flg := byte(0x40)
+	if f.indep {
+		flg |= 0x20
+	}
+	if f.blockSum {
+		flg |= 0x10
+	}
+	if f.contentSize >= 0 {
+		flg |= 0x08
+	}
+	if f.contentSum {
+		flg |= 0x04
+	}
+	desc := []byte{flg, 0x40}
+	if f.contentSize >= 0 {
+		desc = append(desc, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(desc[2:], uint64(f.contentSize))
+	}
+	b := append([]byte{0x04, 0x22, 0x4d, 0x18}, desc...)
+	b = append(b, byte(xxh32(desc)>>8))
+
+	le32 := func(v uint32) {
+		b = append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	}
+	for i, blk := range f.blocks {
+		size := uint32(len(blk))
+		if i < len(f.raw) && f.raw[i] {
+			size |= 0x80000000
+		}
+		le32(size)
+		b = append(b, blk...)
+		if f.blockSum {
+			le32(xxh32(blk))
+		}
+	}
+	le32(0)
+	if f.contentSum {
+		le32(xxh32(content))
+	}
+	return b
+}
+
+func lz4Decode(data []byte) ([]byte, error) {
+	r, err := newLz4Reader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestLz4(t *testing.T) {
+	first := "the quick brown fox "
+	second := "jumps over the lazy dog"
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  string
+	}{
+		{
+			"literals",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{lz4Seq(first, 0, 0)}}.bytes(nil),
+			first,
+		},
+		{
+			"overlapping match",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{
+				append(lz4Seq("ab", 2, 40), lz4Seq("end", 0, 0)...),
+			}}.bytes(nil),
+			strings.Repeat("ab", 21) + "end",
+		},
+		{
+			"independent blocks",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{
+				lz4Seq(first, 0, 0),
+				// each block only refers to its own data
+				append(lz4Seq("jump", 4, 4), lz4Seq("s over the lazy dog", 0, 0)...),
+			}}.bytes(nil),
+			first + "jumpjumps over the lazy dog",
+		},
+		{
+			"linked blocks",
+			lz4Frame{contentSize: -1, blocks: [][]byte{
+				lz4Seq(first, 0, 0),
+				// "the " from the previous block, then "lazy dog"
+				append(lz4Seq(second[:15], len(first)+15, 4), lz4Seq("lazy dog", 0, 0)...),
+			}}.bytes(nil),
+			first + second[:15] + "the lazy dog",
+		},
+		{
+			"raw block",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{[]byte(first)}, raw: []bool{true}}.bytes(nil),
+			first,
+		},
+		{
+			"content size and checksums",
+			lz4Frame{indep: true, blockSum: true, contentSum: true, contentSize: int64(len(first)), blocks: [][]byte{lz4Seq(first, 0, 0)}}.bytes([]byte(first)),
+			first,
+		},
+		{
+			"concatenated frames",
+			append(
+				lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{lz4Seq(first, 0, 0)}}.bytes(nil),
+				lz4Frame{indep: true, contentSum: true, contentSize: -1, blocks: [][]byte{lz4Seq(second, 0, 0)}}.bytes([]byte(second))...,
+			),
+			first + second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lz4Decode(tt.frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLz4Invalid(t *testing.T) {
+	text := "the quick brown fox"
+	valid := lz4Frame{indep: true, blockSum: true, contentSum: true, contentSize: int64(len(text)), blocks: [][]byte{lz4Seq(text, 0, 0)}}.bytes([]byte(text))
+
+	tests := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"truncated magic", valid[:2], nil},
+		{"truncated header", valid[:6], nil},
+		{"truncated block size", valid[:17], io.ErrUnexpectedEOF},
+		{"truncated block", valid[:len(valid)-20], io.ErrUnexpectedEOF},
+		{"truncated block checksum", valid[:len(valid)-10], io.ErrUnexpectedEOF},
+		{"truncated end mark", valid[:len(valid)-6], io.ErrUnexpectedEOF},
+		{"truncated content checksum", valid[:len(valid)-2], io.ErrUnexpectedEOF},
+		{"bad magic", append([]byte{0x04, 0x22, 0x4d, 0x19}, valid[4:]...), nil},
+		{"bad version", append([]byte{0x04, 0x22, 0x4d, 0x18, 0x80}, valid[5:]...), nil},
+		{"bad block size", append([]byte{0x04, 0x22, 0x4d, 0x18, 0x60, 0x10}, valid[6:]...), nil},
+		{
+			"linked reference in independent block",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{
+				lz4Seq(text, 0, 0),
+				append(lz4Seq("x", 10, 4), lz4Seq("end", 0, 0)...),
+			}}.bytes(nil),
+			errLz4Corrupt,
+		},
+		{
+			"zero offset",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{append(lz4Seq("abc", 0, 4), lz4Seq("end", 0, 0)...)}}.bytes(nil),
+			errLz4Corrupt,
+		},
+		{
+			"missing offset",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{append(lz4Seq("abc", 0, 4)[:4], 1)}}.bytes(nil),
+			errLz4Corrupt,
+		},
+		{
+			"literals past end",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{lz4Seq(text, 0, 0)[:10]}}.bytes(nil),
+			errLz4Corrupt,
+		},
+		{
+			"block too large",
+			lz4Frame{indep: true, contentSize: -1, blocks: [][]byte{lz4Seq("a", 1, 70000)}}.bytes(nil),
+			errLz4Corrupt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := lz4Decode(tt.frame)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSniffLz4(t *testing.T) {
+	content := makeTar(t, []tarEntry{{name: "tool", typ: '0', mode: 0755, data: "binary"}})
+	// the tar data is stored uncompressed, in blocks of at most 64KB
+	var blocks [][]byte
+	var raw []bool
+	for len(content) > 0 {
+		n := len(content)
+		if n > 64<<10 {
+			n = 64 << 10
+		}
+		blocks = append(blocks, content[:n])
+		raw = append(raw, true)
+		content = content[n:]
+	}
+	frame := lz4Frame{indep: true, contentSize: -1, blocks: blocks, raw: raw}.bytes(nil)
+
+	comp, container := SniffArchive(bytes.NewReader(frame))
+	if comp != FormatLz4 || container != FormatTar {
+		t.Errorf("format = %v, %v, want lz4, tar", comp, container)
+	}
+	if f := Sniff([]byte("\x04\x22\x4d")); f != FormatUnknown {
+		t.Errorf("short magic was recognized as %v", f)
+	}
+}
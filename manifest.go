@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GetDataDir returns the directory where eget stores data about installs. It
+// is $EGET_DATA if set, or 'eget' in the OS's user data directory.
+func GetDataDir() (string, error) {
+	if dir := os.Getenv("EGET_DATA"); dir != "" {
+		return dir, nil
+	}
+	dataDir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "eget"), nil
+}
+
+// userDataDir returns the OS's user data directory, which is $XDG_DATA_HOME
+// (default ~/.local/share), or %LocalAppData% on Windows.
+func userDataDir() (string, error) {
+	var dataDir string
+	switch runtime.GOOS {
+	case "windows":
+		dataDir = os.Getenv("LOCALAPPDATA")
+	default:
+		dataDir = os.Getenv("XDG_DATA_HOME")
+	}
+	if dataDir == "" {
+		homePath, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataDir = filepath.Join(homePath, ".local", "share")
+	}
+	return dataDir, nil
+}
+
+// An Install records a file or directory that eget installed.
+type Install struct {
+	Target  string    `json:"target"`            // absolute path of the install
+	Project string    `json:"project,omitempty"` // repo or URL it was installed from
+	URL     string    `json:"url,omitempty"`     // URL of the asset
+	Tag     string    `json:"tag,omitempty"`     // tag of the release
+	Path    string    `json:"path,omitempty"`    // versioned file the target links to
+	Backup  string    `json:"backup,omitempty"`  // previous version of the target
+	Time    time.Time `json:"time"`
+
+	Files []InstalledFile `json:"files,omitempty"` // everything the install created
+}
+
+// An InstalledFile is a file, directory or symlink created by an install. The
+// hash of files and the target of symlinks are recorded so that files that
+// were changed after the install are not removed.
+type InstalledFile struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"` // "file", "dir" or "symlink"
+	SHA256 string `json:"sha256,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// A Manifest records the installs made by eget, keyed by target path.
+type Manifest struct {
+	Installs map[string]*Install `json:"installs"`
+
+	path string
+}
+
+// LoadManifest reads the manifest from the data directory. A missing
+// manifest is treated as empty.
+func LoadManifest() (*Manifest, error) {
+	dir, err := GetDataDir()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		Installs: make(map[string]*Install),
+		path:     filepath.Join(dir, "manifest.json"),
+	}
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", m.path, err)
+	}
+	if m.Installs == nil {
+		m.Installs = make(map[string]*Install)
+	}
+	return m, nil
+}
+
+// Save writes the manifest, replacing the previous one atomically.
+func (m *Manifest) Save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// Record adds or replaces the install of a target.
+func (m *Manifest) Record(in *Install) {
+	m.Installs[in.Target] = in
+}
+
+// Find returns the installs that match 'name', which may be the path of a
+// target, the name of a target, or the project it was installed from.
+func (m *Manifest) Find(name string) []*Install {
+	abs, _ := filepath.Abs(name)
+	var found []*Install
+	for _, in := range m.Installs {
+		if in.Target == abs || filepath.Base(in.Target) == name || in.Project == name {
+			found = append(found, in)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Target < found[j].Target
+	})
+	return found
+}
+
+// FindOne is like Find, but requires exactly one install to match.
+func (m *Manifest) FindOne(name string) (*Install, error) {
+	found := m.Find(name)
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no install of %s found", name)
+	case 1:
+		return found[0], nil
+	}
+	targets := make([]string, len(found))
+	for i, in := range found {
+		targets[i] = in.Target
+	}
+	return nil, fmt.Errorf("%s matches multiple installs: %s", name, strings.Join(targets, ", "))
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// A partDownload is a download that is written to a .part file in the cache
+// directory, so that it can be resumed if it fails. The validator (the ETag
+// or Last-Modified time of the response) is kept next to it, so that the data
+// is only resumed if it has not changed on the server. The data is passed on
+// to the output as it is received, so the .part file is only a checkpoint
+// that is not read again, except for data from a previous run that is resumed
+// and for segmented downloads, whose segments arrive out of order.
+type partDownload struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"`
+
+	f       *os.File
+	path    string
+	source  string // the mirror that the data is downloaded from
+	written int64  // how much of the data has been written to the output
+
+	// Cached is the cache entry of the URL, which is revalidated instead of
+	// downloading the data again if it has not changed.
+	Cached *CacheEntry
+	// the validators of the response, for caching the data
+	etag     string
+	modified string
+}
+
+// errNotModified is returned by Fetch if the cached data is still valid.
+var errNotModified = errors.New("not modified")
+
+// openPart opens the .part file of a download of 'url', which may contain
+// data from a previous attempt.
+func openPart(url string) (*partDownload, error) {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "partial")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	p := &partDownload{
+		path: filepath.Join(dir, fmt.Sprintf("%x.part", sha256.Sum256([]byte(url)))),
+	}
+	if data, err := os.ReadFile(p.metaPath()); err == nil {
+		json.Unmarshal(data, p)
+	}
+	if p.URL != url {
+		p.URL = url
+		p.Validator = ""
+	}
+	p.f, err = os.OpenFile(p.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if p.Validator == "" {
+		// data without a validator can't be resumed
+		if err := p.truncate(); err != nil {
+			p.f.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *partDownload) metaPath() string {
+	return p.path + ".json"
+}
+
+func (p *partDownload) size() int64 {
+	fi, err := p.f.Stat()
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+func (p *partDownload) truncate() error {
+	if err := p.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := p.f.Seek(0, io.SeekStart)
+	return err
+}
+
+func (p *partDownload) saveMeta() error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.metaPath(), data, 0644)
+}
+
+// Close closes the .part file, keeping it so that the download can be
+// resumed later.
+func (p *partDownload) Close() {
+	p.f.Close()
+}
+
+// Remove removes the .part file once the download is no longer needed.
+func (p *partDownload) Remove() {
+	p.f.Close()
+	os.Remove(p.path)
+	os.Remove(p.metaPath())
+}
+
+// catchUp writes the data in the .part file that the output does not have
+// yet, up to 'offset'.
+func (p *partDownload) catchUp(out io.Writer, offset int64) error {
+	if offset <= p.written {
+		return nil
+	}
+	n, err := io.Copy(out, io.NewSectionReader(p.f, p.written, offset-p.written))
+	p.written += n
+	return err
+}
+
+// An outputWriter passes the data of a download that starts at 'off' on to
+// the output. If the download was restarted from an earlier offset, the data
+// that the output already has is skipped.
+type outputWriter struct {
+	p   *partDownload
+	out io.Writer
+	off int64
+}
+
+func (w *outputWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if skip := w.p.written - w.off; skip > 0 {
+		if skip >= int64(n) {
+			w.off += int64(n)
+			return n, nil
+		}
+		b = b[skip:]
+		w.off += skip
+	}
+	m, err := w.out.Write(b)
+	w.off += int64(m)
+	w.p.written += int64(m)
+	if err != nil {
+		return n - len(b) + m, err
+	}
+	return n, nil
+}
+
+// contentStart returns the offset of the first byte of a 206 Partial Content
+// response, which has a Content-Range header such as 'bytes 100-199/200'.
+func contentStart(resp *http.Response) int64 {
+	cr := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	start, _, _ := Cut(cr, "-")
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// Fetch downloads 'url' into the .part file, and writes the data to 'out'. If
+// the file contains data from a previous attempt, only the rest of the data is
+// requested with a Range request, which uses If-Range so that the server sends
+// all of the data if it has changed. Servers that don't support ranges send
+// all of the data, which replaces the partial data; the data that 'out'
+// already has is skipped, and if it changed, the download fails, since 'out'
+// can't be rewound.
+func (p *partDownload) Fetch(out io.Writer, getbar func(size int64) *pb.ProgressBar) error {
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		return err
+	}
+	req = SetAuthHeader(req)
+
+	offset := p.size()
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", p.Validator)
+	} else if p.Cached != nil {
+		if p.Cached.ETag != "" {
+			req.Header.Set("If-None-Match", p.Cached.ETag)
+		}
+		if p.Cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", p.Cached.LastModified)
+		}
+	}
+
+	p.source = ""
+	resp, err := doMirrors(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	p.source = originURL(resp)
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && offset == 0 && p.Cached != nil:
+		return errNotModified
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && contentStart(resp) == offset:
+		if _, err := p.f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	case (resp.StatusCode == http.StatusRequestedRangeNotSatisfiable || resp.StatusCode == http.StatusPartialContent) && offset > 0:
+		// the partial data is not part of the current file, or the server
+		// sent a different range than requested
+		if err := p.truncate(); err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return p.Fetch(out, getbar)
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+		if err := p.truncate(); err != nil {
+			return err
+		}
+	default:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("download error: %d: %s", resp.StatusCode, body)
+	}
+
+	p.etag, p.modified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	// only strong ETags may be used with If-Range
+	validator := resp.Header.Get("Last-Modified")
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		validator = etag
+	}
+	if offset < p.written && (validator == "" || validator != p.Validator) {
+		return fmt.Errorf("%s changed on the server while it was downloaded", p.URL)
+	}
+	p.Validator = validator
+	// a segmented download has holes until all segments are done, so it
+	// can't be resumed from its size
+	segments := numSegments(resp, p.Validator)
+	if p.Validator != "" && segments == 1 {
+		p.saveMeta()
+	} else {
+		os.Remove(p.metaPath())
+	}
+
+	size := int64(-1)
+	if resp.ContentLength >= 0 {
+		size = offset + resp.ContentLength
+	}
+	bar := getbar(size)
+	if segments > 1 {
+		if err := p.fetchSegments(resp, segments, bar); err != nil {
+			return err
+		}
+		return p.catchUp(out, size)
+	}
+	if offset > 0 {
+		bar.Describe("Resuming")
+		bar.Set64(offset)
+	}
+	// data from a previous run that is resumed
+	if err := p.catchUp(out, offset); err != nil {
+		return err
+	}
+	w := &outputWriter{p: p, out: out, off: offset}
+	if _, err := io.Copy(io.MultiWriter(p.f, w, bar), resp.Body); err != nil {
+		// the data that was received is kept, so the rest can be requested
+		// when it is retried
+		return &retryableError{err}
+	}
+	return nil
+}
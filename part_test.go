@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer serves 'data' with an ETag, and supports ranges unless
+// 'noRanges' is set. The first 'cut' responses are cut off halfway.
+type rangeServer struct {
+	sync.Mutex
+	data     []byte
+	noRanges bool
+	cut      int
+	next     []byte   // the data after the responses that are cut off
+	ranges   []string // the Range header of each request
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	data, cut := s.data, s.cut > 0
+	if cut {
+		s.cut--
+		if s.next != nil {
+			s.data = s.next
+		}
+	}
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.Unlock()
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(data)))
+	if !s.noRanges {
+		if cut {
+			w = &cutWriter{ResponseWriter: w, left: len(data) / 2}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if cut {
+		data = data[:len(data)/2]
+	}
+	w.Write(data)
+}
+
+// A cutWriter stops writing the response after 'left' bytes, so the
+// connection is closed before all of the data is sent.
+type cutWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (w *cutWriter) Write(b []byte) (int, error) {
+	if len(b) > w.left {
+		b = b[:w.left]
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.left -= n
+	if err == nil && w.left == 0 {
+		err = fmt.Errorf("cut off")
+	}
+	return n, err
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	return data
+}
+
+// partFiles returns the files in the directory of partial downloads.
+func partFiles(t *testing.T) []string {
+	t.Helper()
+	dir, _ := GetCacheDir()
+	entries, _ := os.ReadDir(filepath.Join(dir, "partial"))
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestDownloadResume(t *testing.T) {
+	tests := []struct {
+		name     string
+		noRanges bool
+		ranges   []string
+	}{
+		{"ranges", false, []string{"", "bytes=16384-"}},
+		// the server sends all of the data again, and the part that was
+		// already written is skipped
+		{"no ranges", true, []string{"", "bytes=16384-"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setOpts(t, Flags{Retries: 1, NoCache: true})
+			t.Setenv("EGET_CACHE", t.TempDir())
+			s := &rangeServer{data: testData(32768), noRanges: tt.noRanges, cut: 1}
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+
+			got := download(t, srv.URL+"/tool")
+			if !bytes.Equal([]byte(got), s.data) {
+				t.Errorf("downloaded %d bytes, which are not the data", len(got))
+			}
+			if strings.Join(s.ranges, ",") != strings.Join(tt.ranges, ",") {
+				t.Errorf("ranges = %q, want %q", s.ranges, tt.ranges)
+			}
+			if files := partFiles(t); len(files) != 0 {
+				t.Errorf("partial downloads were left behind: %v", files)
+			}
+		})
+	}
+}
+
+func TestDownloadResumeLater(t *testing.T) {
+	setOpts(t, Flags{NoCache: true})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	s := &rangeServer{data: testData(32768), cut: 1}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	if err := Download(srv.URL+"/tool", &buf, progressBar); err == nil {
+		t.Fatal("a download that was cut off succeeded")
+	}
+	if len(partFiles(t)) == 0 {
+		t.Fatal("the partial download was not kept")
+	}
+	// the next run writes the data in the .part file to the output, and
+	// requests the rest
+	if got := download(t, srv.URL+"/tool"); !bytes.Equal([]byte(got), s.data) {
+		t.Errorf("downloaded %d bytes, which are not the data", len(got))
+	}
+	if want := []string{"", "bytes=16384-"}; strings.Join(s.ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("ranges = %q, want %q", s.ranges, want)
+	}
+}
+
+func TestDownloadChanged(t *testing.T) {
+	for _, noRanges := range []bool{false, true} {
+		setOpts(t, Flags{Retries: 1, NoCache: true})
+		t.Setenv("EGET_CACHE", t.TempDir())
+		s := &rangeServer{data: testData(32768), noRanges: noRanges, cut: 1, next: testData(1000)}
+		srv := httptest.NewServer(s)
+
+		// the first half of the old data was written to the output, which
+		// can't be undone
+		var buf bytes.Buffer
+		err := Download(srv.URL+"/tool", &buf, progressBar)
+		if err == nil || !strings.Contains(err.Error(), "changed") {
+			t.Errorf("noRanges=%v: err = %v, want a changed error", noRanges, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestDownloadSegments(t *testing.T) {
+	setOpts(t, Flags{Segments: 4})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	s := &rangeServer{data: testData(4 * minSegmentSize)}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	if got := download(t, srv.URL+"/tool"); !bytes.Equal([]byte(got), s.data) {
+		t.Errorf("downloaded %d bytes, which are not the data", len(got))
+	}
+	if len(s.ranges) != 4 {
+		t.Errorf("%d requests, want 4", len(s.ranges))
+	}
+	// the segmented download was cached as a whole
+	cache, err := OpenCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := cache.Entries[srv.URL+"/tool"]; e == nil || e.SHA256 != fmt.Sprintf("%x", sha256.Sum256(s.data)) {
+		t.Errorf("cache entry = %+v", e)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// the delay before the first retry, which doubles for each retry up to
+// maxRetryDelay
+const (
+	retryDelay    = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// A retryableError is a failure that may succeed if it is tried again, such
+// as a download that was cut off.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+func isRetryable(err error) bool {
+	var re *retryableError
+	return errors.As(err, &re)
+}
+
+// backoff returns how long to wait before retry number 'attempt' (starting
+// at 0): an exponentially increasing delay, with random jitter so that many
+// clients don't retry at the same time.
+func backoff(attempt int) time.Duration {
+	d := retryDelay << attempt
+	if d > maxRetryDelay || d <= 0 {
+		d = maxRetryDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// certificateError returns true if 'err' is caused by a certificate that
+// failed to verify, which does not change when it is retried.
+func certificateError(err error) bool {
+	var unknown x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	return errors.As(err, &unknown) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
+
+// retryAfter returns the delay requested by a Retry-After header, if any.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	s := resp.Header.Get("Retry-After")
+	if s == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(s); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// rateLimitReset returns how long until the GitHub rate limit resets, if the
+// response says that it is exhausted.
+func rateLimitReset(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	// wait an extra second in case the clocks differ slightly
+	return time.Until(time.Unix(reset, 0)) + time.Second, true
+}
+
+// retry waits before retrying a request that failed with 'reason'.
+func retry(reason string, attempt int, wait time.Duration) {
+	fmt.Fprintf(os.Stderr, "warning: %s; retrying in %s (%d of %d)\n", reason, wait.Round(time.Second/10), attempt+1, opts.Retries)
+	time.Sleep(wait)
+}
+
+// rewind resets the body of a request that has one, such as a GraphQL query,
+// so that it can be sent again.
+func rewind(req *http.Request) error {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+// doRequest sends a request, retrying up to --retries times if the
+// connection fails or the server responds with 429 Too Many Requests or a
+// 5xx error. If the GitHub rate limit is exhausted, it waits for the limit
+// to reset if that is within the time allowed by rate_limit_wait, and
+// otherwise returns the response. Waiting for the rate limit counts as a
+// retry, so a limit that is still exhausted after it should have reset is not
+// retried forever.
+func doRequest(req *http.Request) (*http.Response, error) {
+	client := httpClient()
+	for attempt := 0; ; attempt++ {
+		if err := rewind(req); err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if attempt >= opts.Retries || req.Context().Err() != nil || certificateError(err) {
+				return nil, err
+			}
+			retry(err.Error(), attempt, backoff(attempt))
+			continue
+		}
+
+		if reset, ok := rateLimitReset(resp); ok {
+			if opts.RateWait <= 0 || reset > opts.RateWait || attempt >= opts.Retries {
+				return resp, nil
+			}
+			// the reset time may have passed already
+			if reset < retryDelay {
+				reset = retryDelay
+			}
+			resp.Body.Close()
+			fmt.Fprintf(os.Stderr, "GitHub API rate limit exceeded; waiting %s until it resets\n", reset.Round(time.Second))
+			time.Sleep(reset)
+			continue
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if attempt >= opts.Retries {
+			return resp, nil
+		}
+		resp.Body.Close()
+		wait, ok := retryAfter(resp)
+		if !ok || wait > maxRetryDelay {
+			wait = backoff(attempt)
+		}
+		retry(resp.Status, attempt, wait)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rateLimited responds that the rate limit is exhausted, with a reset time
+// that has already passed, to the first 'limited' requests.
+func rateLimited(limited int32, requests *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= limited {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+			http.Error(w, "rate limit exceeded", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ok"))
+	}
+}
+
+func TestRateLimitRetries(t *testing.T) {
+	setOpts(t, Flags{Retries: 2, RateWait: time.Minute})
+	var requests int32
+	srv := httptest.NewServer(rateLimited(100, &requests))
+	defer srv.Close()
+
+	start := time.Now()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err := doRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// the limit is still exhausted after the reset, so the response is
+	// returned once the retries are used up
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", resp.StatusCode)
+	}
+	if requests != 3 {
+		t.Errorf("%d requests, want 3", requests)
+	}
+	// each retry waits at least the minimum delay
+	if d := time.Since(start); d < 2*retryDelay {
+		t.Errorf("retried after %s", d)
+	}
+}
+
+func TestRateLimitReset(t *testing.T) {
+	setOpts(t, Flags{Retries: 2, RateWait: time.Minute})
+	var requests int32
+	srv := httptest.NewServer(rateLimited(1, &requests))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err := doRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests != 2 {
+		t.Errorf("status %d after %d requests, want 200 after 2", resp.StatusCode, requests)
+	}
+
+	// without rate_limit_wait, the response is returned right away
+	setOpts(t, Flags{Retries: 2})
+	requests = 0
+	req, _ = http.NewRequest("GET", srv.URL, nil)
+	if resp, err = doRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || requests != 1 {
+		t.Errorf("status %d after %d requests, want 403 after 1", resp.StatusCode, requests)
+	}
+}
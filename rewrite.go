@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A Rewrite replaces URLs that start with a prefix or match a regular
+// expression with the URLs of one or more mirrors, which are tried in order.
+type Rewrite struct {
+	Prefix  string
+	Regex   *regexp.Regexp
+	Replace []string
+}
+
+// NewRewrite returns the rewrite rule for the 'prefix' or 'regex' (only one
+// may be given) and its replacements.
+func NewRewrite(prefix, regex string, replace []string) (Rewrite, error) {
+	r := Rewrite{
+		Prefix:  prefix,
+		Replace: replace,
+	}
+	if (prefix == "") == (regex == "") {
+		return r, fmt.Errorf("rewrite rule must have either a prefix or a regex")
+	}
+	if len(replace) == 0 {
+		return r, fmt.Errorf("rewrite rule for %s%s has no replacements", prefix, regex)
+	}
+	if regex != "" {
+		var err error
+		r.Regex, err = regexp.Compile(regex)
+		if err != nil {
+			return r, fmt.Errorf("rewrite rule: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Match returns the rewritten URLs if the rule applies to 'u'.
+func (r Rewrite) Match(u string) ([]string, bool) {
+	urls := make([]string, 0, len(r.Replace))
+	switch {
+	case r.Regex != nil && r.Regex.MatchString(u):
+		for _, repl := range r.Replace {
+			urls = append(urls, r.Regex.ReplaceAllString(u, repl))
+		}
+	case r.Regex == nil && strings.HasPrefix(u, r.Prefix):
+		for _, repl := range r.Replace {
+			urls = append(urls, repl+strings.TrimPrefix(u, r.Prefix))
+		}
+	default:
+		return nil, false
+	}
+	return urls, true
+}
+
+// Mirrors returns the URLs to try for 'u', in order. They are given by the
+// first rewrite rule that applies to it, or 'u' itself if no rule applies.
+func Mirrors(u string) []string {
+	for _, r := range opts.Rewrites {
+		if urls, ok := r.Match(u); ok {
+			return urls
+		}
+	}
+	return []string{u}
+}
+
+// doMirrors sends 'req' to each of the mirrors of its URL in turn, until one
+// of them succeeds, and returns the response of the last one otherwise. The
+// authorization for each mirror is set for its own host, so a GitHub token is
+// not sent to a mirror.
+func doMirrors(req *http.Request) (*http.Response, error) {
+	urls := Mirrors(req.URL.String())
+	for i, u := range urls {
+		mreq := req
+		if u != req.URL.String() {
+			parsed, err := url.Parse(u)
+			if err != nil {
+				return nil, err
+			}
+			mreq = req.Clone(req.Context())
+			mreq.URL = parsed
+			mreq.Host = parsed.Host
+			mreq.Header.Del("Authorization")
+			mreq = SetAuthHeader(mreq)
+		}
+
+		resp, err := doRequest(mreq)
+		if i == len(urls)-1 {
+			return resp, err
+		}
+		if err == nil && resp.StatusCode < 400 {
+			return resp, nil
+		}
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			resp.Body.Close()
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s: %s; trying %s\n", u, reason, urls[i+1])
+	}
+	panic("unreachable")
+}
+
+// originURL returns the URL that was requested for 'resp', before any
+// redirects, which is the mirror that it was served by.
+func originURL(resp *http.Response) string {
+	req := resp.Request
+	for req.Response != nil {
+		req = req.Response.Request
+	}
+	return req.URL.String()
+}
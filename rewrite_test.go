@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewRewrite(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		regex   string
+		replace []string
+		err     string
+	}{
+		{"https://github.com/", "", []string{"https://mirror.example.com/"}, ""},
+		{"", `^https://github\.com/(.*)$`, []string{"https://mirror.example.com/$1"}, ""},
+		{"", "", []string{"https://mirror.example.com/"}, "either a prefix or a regex"},
+		{"https://github.com/", `^https://`, []string{"https://mirror.example.com/"}, "either a prefix or a regex"},
+		{"https://github.com/", "", nil, "no replacements"},
+		{"", "(", []string{"https://mirror.example.com/"}, "rewrite rule:"},
+	}
+	for _, tt := range tests {
+		_, err := NewRewrite(tt.prefix, tt.regex, tt.replace)
+		if tt.err == "" && err != nil {
+			t.Errorf("NewRewrite(%q, %q): %v", tt.prefix, tt.regex, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("NewRewrite(%q, %q): err = %v, want %q", tt.prefix, tt.regex, err, tt.err)
+		}
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	rule := func(prefix, regex string, replace ...string) Rewrite {
+		r, err := NewRewrite(prefix, regex, replace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	setOpts(t, Flags{Rewrites: []Rewrite{
+		rule("https://github.com/", "", "https://mirror1.example.com/gh/", "https://github.com/"),
+		rule("", `^https://api\.github\.com/repos/([^/]+)/([^/]+)/`, "https://api.example.com/$2/$1/"),
+		// never used, because the first rule applies
+		rule("https://github.com/user/", "", "https://other.example.com/"),
+	}})
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"https://github.com/user/tool/releases/download/v1/tool.tar.gz", []string{
+			"https://mirror1.example.com/gh/user/tool/releases/download/v1/tool.tar.gz",
+			"https://github.com/user/tool/releases/download/v1/tool.tar.gz",
+		}},
+		{"https://api.github.com/repos/user/tool/releases/latest", []string{
+			"https://api.example.com/tool/user/releases/latest",
+		}},
+		{"https://example.com/tool.tar.gz", []string{"https://example.com/tool.tar.gz"}},
+		// prefixes are matched exactly
+		{"https://github.company.com/tool.tar.gz", []string{"https://github.company.com/tool.tar.gz"}},
+	}
+	for _, tt := range tests {
+		if got := Mirrors(tt.url); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Mirrors(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadMirrors(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	handler := func(status int, auth *string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, r.Host+r.URL.Path)
+			*auth = r.Header.Get("Authorization")
+			mu.Unlock()
+			if status != http.StatusOK {
+				http.Error(w, "mirror is down", status)
+				return
+			}
+			fmt.Fprint(w, "tool data")
+		}
+	}
+	var downAuth, upAuth string
+	down := httptest.NewServer(handler(http.StatusBadGateway, &downAuth))
+	defer down.Close()
+	up := httptest.NewServer(handler(http.StatusOK, &upAuth))
+	defer up.Close()
+	closed := httptest.NewServer(nil)
+	closed.Close()
+
+	rw, err := NewRewrite("https://github.com/", "", []string{closed.URL + "/", down.URL + "/", up.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setOpts(t, Flags{NoCache: true, Rewrites: []Rewrite{rw}})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	t.Setenv("EGET_GITHUB_TOKEN", "secret")
+
+	var data string
+	stderr := captureStderr(t, func() {
+		data = download(t, "https://github.com/user/tool/releases/download/v1/tool.tar.gz")
+	})
+	if data != "tool data" {
+		t.Errorf("downloaded %q", data)
+	}
+	// the unreachable mirror has no request, and the failed mirror has one
+	want := []string{
+		strings.TrimPrefix(down.URL, "http://") + "/user/tool/releases/download/v1/tool.tar.gz",
+		strings.TrimPrefix(up.URL, "http://") + "/user/tool/releases/download/v1/tool.tar.gz",
+	}
+	if strings.Join(requests, " ") != strings.Join(want, " ") {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+	if strings.Count(stderr, "warning:") != 2 || !strings.Contains(stderr, "502 Bad Gateway; trying "+up.URL) {
+		t.Errorf("stderr = %q, want a warning for each failed mirror", stderr)
+	}
+	// the GitHub token is not sent to mirrors
+	if downAuth != "" || upAuth != "" {
+		t.Errorf("authorization sent to a mirror: %q, %q", downAuth, upAuth)
+	}
+}
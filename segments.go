@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// segments are at least this large, so small assets are downloaded over a
+// single connection
+const minSegmentSize = 1 << 20
+
+// An offsetWriter writes to a file starting at an offset, so segments can be
+// written concurrently.
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(b []byte) (int, error) {
+	n, err := w.f.WriteAt(b, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// numSegments returns the number of segments to download a response in, or
+// 1 if it should not be segmented. The server must support ranges, and the
+// length and a validator (to check that each segment is from the same data)
+// must be known.
+func numSegments(resp *http.Response, validator string) int {
+	if opts.Segments <= 1 || resp.StatusCode != http.StatusOK || validator == "" {
+		return 1
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength <= 0 {
+		return 1
+	}
+	n := opts.Segments
+	if max := resp.ContentLength / minSegmentSize; int64(n) > max {
+		n = int(max)
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// fetchSegment downloads the bytes from the offset of 'w' to 'end'
+// (exclusive) into the .part file with Range requests. A segment that is cut
+// off is retried from where it stopped.
+func (p *partDownload) fetchSegment(w *offsetWriter, end int64, bar *pb.ProgressBar) error {
+	for attempt := 0; ; attempt++ {
+		err := p.fetchRange(w, end, bar)
+		if err == nil {
+			return nil
+		}
+		if !isRetryable(err) || attempt >= opts.Retries {
+			return err
+		}
+		retry(err.Error(), attempt, backoff(attempt))
+	}
+}
+
+func (p *partDownload) fetchRange(w *offsetWriter, end int64, bar *pb.ProgressBar) error {
+	// all segments are downloaded from the same mirror
+	req, err := http.NewRequest("GET", p.source, nil)
+	if err != nil {
+		return err
+	}
+	req = SetAuthHeader(req)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", w.off, end-1))
+	req.Header.Set("If-Range", p.Validator)
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || contentStart(resp) != w.off {
+		// the data changed on the server since the download started
+		return fmt.Errorf("download error: %s for bytes %d-%d", resp.Status, w.off, end-1)
+	}
+	return copySegment(w, resp.Body, end, bar)
+}
+
+// copySegment copies the data from 'r' to 'w' until the offset of 'w' reaches
+// 'end'.
+func copySegment(w *offsetWriter, r io.Reader, end int64, bar *pb.ProgressBar) error {
+	want := end - w.off
+	n, err := io.Copy(io.MultiWriter(w, bar), io.LimitReader(r, want))
+	if err == nil && n < want {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return &retryableError{err}
+	}
+	return nil
+}
+
+// fetchSegments downloads the data of 'resp' in 'n' segments concurrently,
+// into the .part file, which is preallocated. The first segment is read from
+// 'resp' itself, and the others are requested with Range requests. The
+// segments all write to the same progress bar.
+func (p *partDownload) fetchSegments(resp *http.Response, n int, bar *pb.ProgressBar) error {
+	size := resp.ContentLength
+	if err := p.f.Truncate(size); err != nil {
+		return err
+	}
+
+	seglen := (size + int64(n) - 1) / int64(n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 1; i < n; i++ {
+		start := int64(i) * seglen
+		end := start + seglen
+		if end > size {
+			end = size
+		}
+		wg.Add(1)
+		go func(i int, w *offsetWriter, end int64) {
+			defer wg.Done()
+			errs[i] = p.fetchSegment(w, end, bar)
+		}(i, &offsetWriter{f: p.f, off: start}, end)
+	}
+	w := &offsetWriter{f: p.f}
+	if err := copySegment(w, resp.Body, seglen, bar); err != nil {
+		resp.Body.Close()
+		errs[0] = p.fetchSegment(w, seglen, bar)
+	}
+	resp.Body.Close()
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			p.truncate()
+			return err
+		}
+	}
+	return nil
+}
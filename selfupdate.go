@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+)
+
+const selfRepo = "zyedidia/eget"
+
+var channelrgx = regexp.MustCompile(`^v?\d+(\.\d+)?$`)
+
+// selfExecutable returns the path of the running eget, which is replaced by
+// an update.
+var selfExecutable = os.Executable
+
+// selfUpdateFinder returns a finder for the latest release of eget in
+// 'channel', which is "stable" (the default), "pre-release", or a major or
+// major.minor version to stay on, such as "1" or "1.3".
+func selfUpdateFinder(channel string) (*GithubAssetFinder, error) {
+	f := &GithubAssetFinder{
+		Repo: selfRepo,
+		Tag:  "latest",
+	}
+	switch {
+	case channel == "" || channel == "stable":
+	case channel == "pre-release":
+		f.Prerelease = true
+	case channelrgx.MatchString(channel):
+		// matches the most recent release starting with the version
+		f.Tag = fmt.Sprintf("tags/v%s.", strings.TrimPrefix(channel, "v"))
+	default:
+		return nil, fmt.Errorf("invalid update channel %s (must be stable, pre-release, or a version such as 1.3)", channel)
+	}
+	return f, nil
+}
+
+// SelfUpdate replaces the running eget with the latest release in 'channel'
+// if it is more recent than the running version. The release asset must be
+// verified with a checksum from the release.
+func SelfUpdate(channel string, output io.Writer) error {
+	finder, err := selfUpdateFinder(channel)
+	if err != nil {
+		return err
+	}
+	assets, err := finder.Find()
+	if err != nil {
+		return err
+	}
+
+	latest, err := semver.ParseTolerant(finder.Release)
+	if err != nil {
+		return fmt.Errorf("release %s does not have a semantic version: %w", finder.Release, err)
+	}
+	// development builds may not have a semantic version, and are always
+	// updated
+	if current, err := semver.ParseTolerant(Version); err == nil && !latest.GT(current) {
+		fmt.Fprintf(output, "eget %s is up to date (latest release is %s)\n", Version, finder.Release)
+		return nil
+	}
+
+	detector, err := NewSystemDetector(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	url, _, err := detector.Detect(assets)
+	if err != nil {
+		return fmt.Errorf("release %s: %w", finder.Release, err)
+	}
+
+	var verifier Verifier
+	sumAsset := checksumAsset(url, assets)
+	if sumAsset != "" {
+		verifier = &Sha256AssetVerifier{
+			AssetURL: sumAsset,
+		}
+	} else if sumAsset = checksumsAsset(assets); sumAsset != "" {
+		verifier = &Sha256SumsVerifier{
+			AssetURL: sumAsset,
+			Name:     assetName(url),
+		}
+	} else {
+		return fmt.Errorf("release %s has no checksums to verify %s with", finder.Release, assetName(url))
+	}
+
+	fmt.Fprintf(output, "%s\n", url)
+	body := NewSpool()
+	defer body.Close()
+	if err := Download(url, io.MultiWriter(body, verifier), progressBar); err != nil {
+		return fmt.Errorf("%s (URL: %s)", err, url)
+	}
+	if err := verifier.Verify(); err != nil {
+		Uncache(url)
+		return err
+	}
+	fmt.Fprintf(output, "Checksum verified with %s\n", path.Base(sumAsset))
+
+	extractor := NewExtractor(assetName(url), "eget", &BinaryChooser{
+		Tool: "eget",
+	}, body)
+	bin, _, err := extractor.Extract(body, false)
+	if err != nil {
+		return err
+	}
+
+	exe, err := selfExecutable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+	stage, err := NewStage(exe)
+	if err != nil {
+		return err
+	}
+	if err := bin.Extract(stage.Path); err != nil {
+		return err
+	}
+	if out, err := exec.Command(stage.Path, "--version").Output(); err != nil {
+		return fmt.Errorf("the downloaded eget does not run: %w", err)
+	} else if !strings.HasPrefix(string(out), "eget version") {
+		return fmt.Errorf("the downloaded eget printed an unexpected version: %s", out)
+	}
+
+	// on Windows, the running executable can't be replaced, so Commit renames
+	// it to the backup instead, which is allowed while it is running
+	backup, err := stage.Commit()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := LoadManifest()
+	if err == nil {
+		in := &Install{
+			Target:  exe,
+			Project: selfRepo,
+			URL:     url,
+			Tag:     finder.Release,
+			Backup:  backup,
+			Time:    time.Now(),
+		}
+		if err = recordFiles(in); err == nil {
+			manifest.Record(in)
+			err = manifest.Save()
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: could not record install:", err)
+	}
+
+	fmt.Fprintf(output, "Updated eget from %s to %s\n", Version, finder.Release)
+	return nil
+}
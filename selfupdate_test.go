@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// releaseServer serves the latest release of eget, with a tarball of an
+// eget that prints its version, and a checksums file.
+type releaseServer struct {
+	*httptest.Server
+	sync.Mutex
+	sums     string // the checksums file
+	requests []string
+}
+
+func newReleaseServer(t *testing.T, tag string) *releaseServer {
+	t.Helper()
+	version := strings.TrimPrefix(tag, "v")
+	name := fmt.Sprintf("eget-%s-%s_%s.tar.gz", version, runtime.GOOS, runtime.GOARCH)
+	tarball := makeGzip(t, makeTar(t, []tarEntry{
+		{name: "eget-" + version + "/eget", typ: tar.TypeReg, mode: 0755, data: "#!/bin/sh\necho eget version " + version + "\n"},
+	}))
+	s := &releaseServer{sums: fmt.Sprintf("%x  %s\n", sha256.Sum256(tarball), name)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		s.requests = append(s.requests, r.URL.Path)
+		sums := s.sums
+		s.Unlock()
+		switch r.URL.Path {
+		case "/repos/zyedidia/eget/releases/latest":
+			fmt.Fprintf(w, `{"tag_name": %q, "created_at": "2026-01-01T00:00:00Z", "assets": [
+				{"browser_download_url": "%s/download/%s"},
+				{"browser_download_url": "%s/download/checksums.txt"}]}`, tag, s.URL, name, s.URL)
+		case "/download/" + name:
+			w.Write(tarball)
+		case "/download/checksums.txt":
+			w.Write([]byte(sums))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// setupSelfUpdate sends the requests for the GitHub API to 's', and makes
+// 'exe' the running eget.
+func setupSelfUpdate(t *testing.T, s *releaseServer, version string) string {
+	t.Helper()
+	rw, err := NewRewrite("https://api.github.com/", "", []string{s.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setOpts(t, Flags{NoCache: true, Rewrites: []Rewrite{rw}})
+	t.Setenv("EGET_CACHE", t.TempDir())
+	t.Setenv("EGET_DATA", t.TempDir())
+	t.Setenv("EGET_GITHUB_TOKEN", "")
+	t.Setenv("GITHUB_TOKEN", "")
+
+	exe := filepath.Join(t.TempDir(), "eget")
+	if err := os.WriteFile(exe, []byte("old eget"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	savedExe, savedVersion := selfExecutable, Version
+	selfExecutable = func() (string, error) { return exe, nil }
+	Version = version
+	t.Cleanup(func() { selfExecutable, Version = savedExe, savedVersion })
+	return exe
+}
+
+func TestSelfUpdate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the downloaded eget is a shell script")
+	}
+	s := newReleaseServer(t, "v1.4.0")
+	exe := setupSelfUpdate(t, s, "1.3.4")
+
+	var out bytes.Buffer
+	if err := SelfUpdate("stable", &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Updated eget from 1.3.4 to v1.4.0") {
+		t.Errorf("output = %q", out.String())
+	}
+	if got := readString(t, exe); !strings.Contains(got, "echo eget version 1.4.0") {
+		t.Errorf("eget = %q, want the new release", got)
+	}
+	// the old eget is kept as a backup, and the update is recorded
+	m, err := LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins := m.Find(selfRepo)
+	if len(ins) != 1 || ins[0].Tag != "v1.4.0" || ins[0].Target != exe {
+		t.Fatalf("installs = %+v", ins)
+	}
+	if got := readString(t, ins[0].Backup); got != "old eget" {
+		t.Errorf("backup = %q", got)
+	}
+}
+
+func TestSelfUpdateUpToDate(t *testing.T) {
+	s := newReleaseServer(t, "v1.4.0")
+	for _, version := range []string{"1.4.0", "1.5.0"} {
+		exe := setupSelfUpdate(t, s, version)
+		s.requests = nil
+
+		var out bytes.Buffer
+		if err := SelfUpdate("stable", &out); err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("eget %s is up to date (latest release is v1.4.0)\n", version); out.String() != want {
+			t.Errorf("output = %q, want %q", out.String(), want)
+		}
+		if got := readString(t, exe); got != "old eget" {
+			t.Errorf("eget was replaced by %q", got)
+		}
+		// nothing is downloaded
+		if len(s.requests) != 1 {
+			t.Errorf("requests = %v", s.requests)
+		}
+	}
+}
+
+func TestSelfUpdateBadChecksum(t *testing.T) {
+	s := newReleaseServer(t, "v1.4.0")
+	exe := setupSelfUpdate(t, s, "1.3.4")
+	// the checksums file lists a different checksum for the tarball
+	s.sums = fmt.Sprintf("%x  eget-1.4.0-%s_%s.tar.gz\n", sha256.Sum256(nil), runtime.GOOS, runtime.GOARCH)
+
+	if err := SelfUpdate("stable", &bytes.Buffer{}); err == nil {
+		t.Fatal("an asset with the wrong checksum was installed")
+	}
+	if got := readString(t, exe); got != "old eget" {
+		t.Errorf("eget was replaced by %q", got)
+	}
+}
@@ -0,0 +1,1046 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+var sevenZipMagic = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+
+// 7z header property IDs
+const (
+	szEnd                   = 0x00
+	szHeader                = 0x01
+	szArchiveProperties     = 0x02
+	szAdditionalStreamsInfo = 0x03
+	szMainStreamsInfo       = 0x04
+	szFilesInfo             = 0x05
+	szPackInfo              = 0x06
+	szUnpackInfo            = 0x07
+	szSubStreamsInfo        = 0x08
+	szSize                  = 0x09
+	szCRC                   = 0x0a
+	szFolderInfo            = 0x0b
+	szCodersUnpackSize      = 0x0c
+	szNumUnpackStream       = 0x0d
+	szEmptyStream           = 0x0e
+	szEmptyFile             = 0x0f
+	szName                  = 0x11
+	szWinAttributes         = 0x15
+	szEncodedHeader         = 0x17
+	szDummy                 = 0x19
+)
+
+// 7z windows attributes
+const (
+	szAttrDirectory = 0x10
+	szAttrUnixExt   = 0x8000
+)
+
+var errSzCorrupt = errors.New("7z: corrupt header")
+
+// the most coders or coder inputs in a folder, which is far more than any
+// archive uses
+const szMaxCoders = 32
+
+// an encoded header is decompressed into memory, so its size is limited to
+// szHeaderRatio times the size of the archive, and at most szMaxHeader
+const (
+	szHeaderRatio = 1024
+	szMaxHeader   = 256 << 20
+)
+
+// szbuf reads the primitive types of a 7z header. The first error is
+// remembered and all reads after it return zero values.
+type szbuf struct {
+	b   []byte
+	err error
+}
+
+func (s *szbuf) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *szbuf) bytes(n uint64) []byte {
+	if s.err != nil {
+		return nil
+	}
+	if n > uint64(len(s.b)) {
+		s.fail(errSzCorrupt)
+		return nil
+	}
+	b := s.b[:n]
+	s.b = s.b[n:]
+	return b
+}
+
+func (s *szbuf) byte() byte {
+	b := s.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (s *szbuf) uint32() uint32 {
+	b := s.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// number reads a 7z variable length integer. The number of leading one bits
+// in the first byte gives the number of extra bytes.
+func (s *szbuf) number() uint64 {
+	first := s.byte()
+	mask := byte(0x80)
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if first&mask == 0 {
+			high := uint64(first & (mask - 1))
+			return v | high<<(8*i)
+		}
+		v |= uint64(s.byte()) << (8 * i)
+		mask >>= 1
+	}
+	return v
+}
+
+// count reads a number that is used as the length of a list of items. Each
+// item takes at least one byte, so larger counts are rejected.
+func (s *szbuf) count() int {
+	n := s.number()
+	if n > uint64(len(s.b))*8+8 {
+		s.fail(errSzCorrupt)
+		return 0
+	}
+	return int(n)
+}
+
+func (s *szbuf) bits(n int) []bool {
+	v := make([]bool, n)
+	var b byte
+	for i := range v {
+		if i%8 == 0 {
+			b = s.byte()
+		}
+		v[i] = b&(0x80>>(i%8)) != 0
+	}
+	return v
+}
+
+// defined reads a bit vector that may be replaced by an 'all defined' byte.
+func (s *szbuf) defined(n int) []bool {
+	if s.byte() == 0 {
+		return s.bits(n)
+	}
+	v := make([]bool, n)
+	for i := range v {
+		v[i] = true
+	}
+	return v
+}
+
+func (s *szbuf) digests(n int) ([]uint32, []bool) {
+	defined := s.defined(n)
+	crcs := make([]uint32, n)
+	for i := range crcs {
+		if defined[i] {
+			crcs[i] = s.uint32()
+		}
+	}
+	return crcs, defined
+}
+
+func (s *szbuf) expect(id byte) {
+	if got := s.byte(); got != id && s.err == nil {
+		s.fail(fmt.Errorf("7z: unexpected property %#x (expected %#x)", got, id))
+	}
+}
+
+type szCoder struct {
+	id    []byte
+	numIn int
+	props []byte
+}
+
+type szBindPair struct {
+	in, out int
+}
+
+type szFolder struct {
+	coders      []szCoder
+	bindPairs   []szBindPair
+	packed      []int // coder input streams read from pack streams
+	unpackSizes []uint64
+	crc         uint32
+	hasCRC      bool
+	firstPack   int // index of the first pack stream used by this folder
+	substreams  int
+}
+
+type szStreams struct {
+	packPos   uint64
+	packSizes []uint64
+	folders   []*szFolder
+	// substream sizes and checksums, in folder order
+	sizes     []uint64
+	crcs      []uint32
+	hasCRC    []bool
+	subFolder []int
+}
+
+func (s *szbuf) packInfo(st *szStreams) {
+	st.packPos = s.number()
+	n := s.count()
+	st.packSizes = make([]uint64, n)
+	for {
+		switch id := s.byte(); id {
+		case szSize:
+			for i := range st.packSizes {
+				st.packSizes[i] = s.number()
+			}
+		case szCRC:
+			s.digests(n)
+		case szEnd:
+			return
+		default:
+			s.fail(errSzCorrupt)
+			return
+		}
+	}
+}
+
+func (s *szbuf) folder() *szFolder {
+	f := &szFolder{}
+	numOut := 0
+	numIn := 0
+	f.coders = make([]szCoder, s.count())
+	if len(f.coders) == 0 || len(f.coders) > szMaxCoders {
+		s.fail(errSzCorrupt)
+		return f
+	}
+	for i := range f.coders {
+		flags := s.byte()
+		if flags&0x80 != 0 {
+			s.fail(errors.New("7z: alternative coder methods are not supported"))
+			return f
+		}
+		c := &f.coders[i]
+		c.id = s.bytes(uint64(flags & 0x0f))
+		c.numIn = 1
+		if flags&0x10 != 0 {
+			c.numIn = s.count()
+			if c.numIn == 0 || c.numIn > szMaxCoders {
+				s.fail(errSzCorrupt)
+				return f
+			}
+			if s.number() != 1 {
+				s.fail(errors.New("7z: coders with multiple outputs are not supported"))
+				return f
+			}
+		}
+		if flags&0x20 != 0 {
+			c.props = s.bytes(s.number())
+		}
+		numIn += c.numIn
+		numOut++
+	}
+	// every output but the folder's final one is bound to an input
+	f.bindPairs = make([]szBindPair, 0, numOut-1)
+	for i := 0; i < numOut-1; i++ {
+		in, out := s.number(), s.number()
+		if in >= uint64(numIn) || out >= uint64(numOut) || f.bindIn(int(in)) >= 0 || f.bindOut(int(out)) >= 0 {
+			s.fail(errSzCorrupt)
+			return f
+		}
+		f.bindPairs = append(f.bindPairs, szBindPair{int(in), int(out)})
+	}
+	npacked := numIn - len(f.bindPairs)
+	if npacked < 1 {
+		s.fail(errSzCorrupt)
+		return f
+	}
+	if npacked == 1 {
+		for i := 0; i < numIn; i++ {
+			if f.bindIn(i) < 0 {
+				f.packed = append(f.packed, i)
+				break
+			}
+		}
+	} else {
+		for i := 0; i < npacked; i++ {
+			in := s.number()
+			if in >= uint64(numIn) {
+				s.fail(errSzCorrupt)
+				return f
+			}
+			f.packed = append(f.packed, int(in))
+		}
+	}
+	f.unpackSizes = make([]uint64, numOut)
+	return f
+}
+
+func (s *szbuf) unpackInfo(st *szStreams) {
+	s.expect(szFolderInfo)
+	st.folders = make([]*szFolder, s.count())
+	if s.byte() != 0 {
+		s.fail(errors.New("7z: external folders are not supported"))
+		return
+	}
+	pack := 0
+	for i := range st.folders {
+		st.folders[i] = s.folder()
+		st.folders[i].firstPack = pack
+		st.folders[i].substreams = 1
+		pack += len(st.folders[i].packed)
+		if s.err != nil {
+			return
+		}
+	}
+	s.expect(szCodersUnpackSize)
+	for _, f := range st.folders {
+		for i := range f.unpackSizes {
+			f.unpackSizes[i] = s.number()
+		}
+	}
+	for {
+		switch id := s.byte(); id {
+		case szCRC:
+			crcs, defined := s.digests(len(st.folders))
+			for i, f := range st.folders {
+				f.crc, f.hasCRC = crcs[i], defined[i]
+			}
+		case szEnd:
+			return
+		default:
+			s.fail(errSzCorrupt)
+			return
+		}
+	}
+}
+
+func (s *szbuf) subStreamsInfo(st *szStreams) {
+	id := s.byte()
+	if id == szNumUnpackStream {
+		for _, f := range st.folders {
+			f.substreams = s.count()
+		}
+		id = s.byte()
+	}
+
+	for fi, f := range st.folders {
+		if f.substreams == 0 {
+			continue
+		}
+		var sum uint64
+		for i := 1; i < f.substreams; i++ {
+			if id == szSize {
+				size := s.number()
+				st.sizes = append(st.sizes, size)
+				sum += size
+			}
+		}
+		total := f.unpackSize()
+		if sum > total {
+			s.fail(errSzCorrupt)
+			return
+		}
+		st.sizes = append(st.sizes, total-sum)
+		for i := 0; i < f.substreams; i++ {
+			st.subFolder = append(st.subFolder, fi)
+		}
+	}
+	if id == szSize {
+		id = s.byte()
+	}
+
+	st.crcs = make([]uint32, len(st.sizes))
+	st.hasCRC = make([]bool, len(st.sizes))
+	// folders with a single substream and a known checksum use the folder's
+	// checksum, all other substreams are listed here
+	nunknown := 0
+	for _, f := range st.folders {
+		if f.substreams != 1 || !f.hasCRC {
+			nunknown += f.substreams
+		}
+	}
+	for {
+		switch id {
+		case szCRC:
+			crcs, defined := s.digests(nunknown)
+			k, j := 0, 0
+			for _, f := range st.folders {
+				if f.substreams == 1 && f.hasCRC {
+					st.crcs[k], st.hasCRC[k] = f.crc, true
+					k++
+					continue
+				}
+				for i := 0; i < f.substreams; i++ {
+					st.crcs[k], st.hasCRC[k] = crcs[j], defined[j]
+					k++
+					j++
+				}
+			}
+		case szEnd:
+			return
+		default:
+			s.fail(errSzCorrupt)
+			return
+		}
+		id = s.byte()
+	}
+}
+
+func (s *szbuf) streamsInfo() *szStreams {
+	st := &szStreams{}
+	subStreams := false
+	for s.err == nil {
+		switch id := s.byte(); id {
+		case szPackInfo:
+			s.packInfo(st)
+		case szUnpackInfo:
+			s.unpackInfo(st)
+		case szSubStreamsInfo:
+			s.subStreamsInfo(st)
+			subStreams = true
+		case szEnd:
+			// each folder uses the next of the pack streams
+			packed := 0
+			for _, f := range st.folders {
+				packed += len(f.packed)
+			}
+			if len(st.folders) > 0 && packed != len(st.packSizes) {
+				s.fail(errSzCorrupt)
+				return st
+			}
+			if !subStreams {
+				// each folder holds a single stream
+				for i, f := range st.folders {
+					st.sizes = append(st.sizes, f.unpackSize())
+					st.crcs = append(st.crcs, f.crc)
+					st.hasCRC = append(st.hasCRC, f.hasCRC)
+					st.subFolder = append(st.subFolder, i)
+				}
+			}
+			return st
+		default:
+			s.fail(errSzCorrupt)
+		}
+	}
+	return st
+}
+
+type szEntry struct {
+	name      string
+	stream    bool
+	dir       bool
+	attrib    uint32
+	hasAttrib bool
+}
+
+func (s *szbuf) filesInfo() []szEntry {
+	files := make([]szEntry, s.count())
+	for i := range files {
+		files[i].stream = true
+	}
+	var emptyStream []bool
+	for s.err == nil {
+		id := s.byte()
+		if id == szEnd {
+			break
+		}
+		prop := &szbuf{b: s.bytes(s.number())}
+		switch id {
+		case szEmptyStream:
+			emptyStream = prop.bits(len(files))
+			for i := range files {
+				files[i].stream = !emptyStream[i]
+				files[i].dir = emptyStream[i]
+			}
+		case szEmptyFile:
+			nempty := 0
+			for _, e := range emptyStream {
+				if e {
+					nempty++
+				}
+			}
+			emptyFile := prop.bits(nempty)
+			j := 0
+			for i := range files {
+				if !files[i].stream {
+					files[i].dir = !emptyFile[j]
+					j++
+				}
+			}
+		case szName:
+			if prop.byte() != 0 {
+				s.fail(errors.New("7z: external names are not supported"))
+				break
+			}
+			for i := range files {
+				var name []uint16
+				for {
+					c := prop.bytes(2)
+					if c == nil || (c[0] == 0 && c[1] == 0) {
+						break
+					}
+					name = append(name, binary.LittleEndian.Uint16(c))
+				}
+				files[i].name = string(utf16.Decode(name))
+			}
+		case szWinAttributes:
+			defined := prop.defined(len(files))
+			if prop.byte() != 0 {
+				s.fail(errors.New("7z: external attributes are not supported"))
+				break
+			}
+			for i := range files {
+				if defined[i] {
+					files[i].attrib, files[i].hasAttrib = prop.uint32(), true
+				}
+			}
+		}
+		s.fail(prop.err)
+	}
+	return files
+}
+
+func (f *szFolder) bindIn(in int) int {
+	for i, bp := range f.bindPairs {
+		if bp.in == in {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *szFolder) bindOut(out int) int {
+	for i, bp := range f.bindPairs {
+		if bp.out == out {
+			return i
+		}
+	}
+	return -1
+}
+
+// unpackSize returns the size of the folder's final output stream.
+func (f *szFolder) unpackSize() uint64 {
+	for i, size := range f.unpackSizes {
+		if f.bindOut(i) < 0 {
+			return size
+		}
+	}
+	return 0
+}
+
+// reader returns a reader for the folder's final output, given readers for
+// each of its pack streams.
+func (f *szFolder) reader(packs []io.Reader) (io.Reader, error) {
+	for i := range f.unpackSizes {
+		if f.bindOut(i) < 0 {
+			return f.outReader(i, packs, make([]bool, len(f.coders)))
+		}
+	}
+	return nil, errSzCorrupt
+}
+
+// outReader returns a reader for the output stream of coder 'out' (each coder
+// has exactly one output). The coders already in the chain are marked in
+// 'used', so that bind pairs that form a cycle are rejected.
+func (f *szFolder) outReader(out int, packs []io.Reader, used []bool) (io.Reader, error) {
+	if out >= len(f.coders) || used[out] {
+		return nil, errSzCorrupt
+	}
+	used[out] = true
+	c := f.coders[out]
+	if c.numIn != 1 {
+		return nil, fmt.Errorf("7z: unsupported method %x (multiple inputs)", c.id)
+	}
+	in := 0
+	for _, prev := range f.coders[:out] {
+		in += prev.numIn
+	}
+
+	var src io.Reader
+	if bp := f.bindIn(in); bp >= 0 {
+		r, err := f.outReader(f.bindPairs[bp].out, packs, used)
+		if err != nil {
+			return nil, err
+		}
+		src = r
+	} else {
+		for i, p := range f.packed {
+			if p == in && i < len(packs) {
+				src = packs[i]
+			}
+		}
+		if src == nil {
+			return nil, errSzCorrupt
+		}
+	}
+
+	r, err := szDecoder(c, src, f.unpackSizes[out])
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(r, int64(f.unpackSizes[out])), nil
+}
+
+// szDictCap returns the dictionary capacity to use for decoding, which never
+// needs to be larger than the data being decoded.
+func szDictCap(dict uint64, size uint64) int {
+	if size < dict {
+		dict = size
+	}
+	if dict < lzma.MinDictCap {
+		dict = lzma.MinDictCap
+	}
+	if dict > lzma.MaxDictCap {
+		dict = lzma.MaxDictCap
+	}
+	return int(dict)
+}
+
+// szDecoder returns a reader that decodes the data from r with the given
+// coder.
+func szDecoder(c szCoder, r io.Reader, size uint64) (io.Reader, error) {
+	switch hex.EncodeToString(c.id) {
+	case "00":
+		return r, nil
+	case "030101":
+		if len(c.props) != 5 {
+			return nil, errors.New("7z: invalid lzma properties")
+		}
+		// construct a classic lzma header with the known size
+		hdr := make([]byte, lzma.HeaderLen)
+		hdr[0] = c.props[0]
+		dict := szDictCap(uint64(binary.LittleEndian.Uint32(c.props[1:5])), size)
+		binary.LittleEndian.PutUint32(hdr[1:5], uint32(dict))
+		binary.LittleEndian.PutUint64(hdr[5:13], size)
+		return lzma.NewReader(io.MultiReader(bytes.NewReader(hdr), bufio.NewReader(r)))
+	case "21":
+		if len(c.props) != 1 || c.props[0] > 40 {
+			return nil, errors.New("7z: invalid lzma2 properties")
+		}
+		dict := uint64(0xffffffff)
+		if p := c.props[0]; p < 40 {
+			dict = uint64(2|p&1) << (p/2 + 11)
+		}
+		return lzma.Reader2Config{
+			DictCap: szDictCap(dict, size),
+		}.NewReader2(bufio.NewReader(r))
+	case "03030103":
+		return &bcjReader{r: r, prevPos: ^uint32(4)}, nil
+	case "040108":
+		return flate.NewReader(r), nil
+	case "040202":
+		return bzip2.NewReader(r), nil
+	case "06f10701":
+		return nil, errors.New("7z: encrypted archives are not supported")
+	}
+	return nil, fmt.Errorf("7z: unsupported method %x", c.id)
+}
+
+// A bcjReader reverses the x86 BCJ filter, which converts relative call and
+// jump targets into absolute addresses to improve compression.
+type bcjReader struct {
+	r        io.Reader
+	buf      []byte
+	conv     int    // bytes at the start of buf that are ready to be returned
+	pos      uint32 // stream position of buf[0]
+	prevMask uint32
+	prevPos  uint32
+	eof      bool
+}
+
+func (b *bcjReader) Read(p []byte) (int, error) {
+	for b.conv == 0 {
+		if b.eof {
+			if len(b.buf) == 0 {
+				return 0, io.EOF
+			}
+			// the last few bytes can't contain an instruction
+			b.conv = len(b.buf)
+			break
+		}
+		if cap(b.buf) == 0 {
+			b.buf = make([]byte, 0, 64*1024)
+		}
+		n, err := b.r.Read(b.buf[len(b.buf):cap(b.buf)])
+		b.buf = b.buf[:len(b.buf)+n]
+		if err == io.EOF {
+			b.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+		b.conv = b.x86(b.buf)
+	}
+
+	n := copy(p, b.buf[:b.conv])
+	b.conv -= n
+	b.pos += uint32(n)
+	b.buf = b.buf[:copy(b.buf, b.buf[n:])]
+	return n, nil
+}
+
+// x86 decodes the calls and jumps in buf, returning the number of bytes that
+// have been fully processed.
+func (b *bcjReader) x86(buf []byte) int {
+	test := func(c byte) bool { return c == 0 || c == 0xff }
+	allowed := [8]bool{true, true, true, false, true, false, false, false}
+	bitnum := [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+
+	if len(buf) < 5 {
+		return 0
+	}
+	if b.pos-b.prevPos > 5 {
+		b.prevPos = b.pos - 5
+	}
+
+	i := 0
+	for i <= len(buf)-5 {
+		if buf[i] != 0xe8 && buf[i] != 0xe9 {
+			i++
+			continue
+		}
+		offset := b.pos + uint32(i) - b.prevPos
+		b.prevPos = b.pos + uint32(i)
+		if offset > 5 {
+			b.prevMask = 0
+		} else {
+			for j := uint32(0); j < offset; j++ {
+				b.prevMask &= 0x77
+				b.prevMask <<= 1
+			}
+		}
+
+		c := buf[i+4]
+		if test(c) && allowed[(b.prevMask>>1)&7] && b.prevMask>>1 < 0x10 {
+			src := binary.LittleEndian.Uint32(buf[i+1 : i+5])
+			var dest uint32
+			for {
+				dest = src - (b.pos + uint32(i) + 5)
+				if b.prevMask == 0 {
+					break
+				}
+				n := bitnum[b.prevMask>>1]
+				c = byte(dest >> (24 - n*8))
+				if !test(c) {
+					break
+				}
+				src = dest ^ (1<<(32-n*8) - 1)
+			}
+			dest &= 0x01ffffff
+			if dest&0x01000000 != 0 {
+				dest |= 0xff000000
+			}
+			binary.LittleEndian.PutUint32(buf[i+1:i+5], dest)
+			i += 5
+			b.prevMask = 0
+		} else {
+			i++
+			b.prevMask |= 1
+			if test(c) {
+				b.prevMask |= 0x10
+			}
+		}
+	}
+	return i
+}
+
+// A SevenZipArchive reads a 7z archive. Directories and empty files are
+// returned first, followed by the files with data in the order they are
+// stored, so that solid blocks are only decompressed once.
+type SevenZipArchive struct {
+	r       io.ReaderAt
+	streams *szStreams
+	files   []szEntry
+	order   []int // indices into files
+	sub     []int // substream of each file in 'order', or -1
+	idx     int
+
+	folder   int
+	fr       io.Reader
+	left     int64
+	crc      hash.Hash32
+	wantCRC  uint32
+	checkCRC bool
+}
+
+// decodeFolder decompresses an entire folder (used for encoded headers),
+// which must not be larger than 'limit'. The size in the header is not
+// trusted for allocating the data, which grows as it is decompressed.
+func szDecodeFolder(r io.ReaderAt, st *szStreams, fi int, limit uint64) ([]byte, error) {
+	f := st.folders[fi]
+	size := f.unpackSize()
+	if size > limit {
+		return nil, fmt.Errorf("7z: encoded header is too large (%d bytes)", size)
+	}
+	fr, err := szFolderReader(r, st, fi)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(fr, int64(size)))
+	if err != nil {
+		return nil, fmt.Errorf("7z: %w", err)
+	}
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("7z: %w", io.ErrUnexpectedEOF)
+	}
+	if f.hasCRC && crc32.ChecksumIEEE(data) != f.crc {
+		return nil, errors.New("7z: header checksum mismatch")
+	}
+	return data, nil
+}
+
+func szFolderReader(r io.ReaderAt, st *szStreams, fi int) (io.Reader, error) {
+	const sigsize = 32
+	f := st.folders[fi]
+	if f.firstPack+len(f.packed) > len(st.packSizes) {
+		return nil, errSzCorrupt
+	}
+	off := int64(sigsize) + int64(st.packPos)
+	for _, size := range st.packSizes[:f.firstPack] {
+		off += int64(size)
+	}
+	packs := make([]io.Reader, len(f.packed))
+	for i := range packs {
+		size := int64(st.packSizes[f.firstPack+i])
+		packs[i] = io.NewSectionReader(r, off, size)
+		off += size
+	}
+	return f.reader(packs)
+}
+
+func NewSevenZipArchive(src Source, d DecompFn) (Archive, error) {
+	return newSevenZipArchive(src, src.Size())
+}
+
+func newSevenZipArchive(r io.ReaderAt, size int64) (*SevenZipArchive, error) {
+	sig := make([]byte, 32)
+	if _, err := r.ReadAt(sig, 0); err != nil {
+		return nil, fmt.Errorf("7z: %w", err)
+	}
+	if !bytes.Equal(sig[:6], sevenZipMagic) {
+		return nil, errors.New("7z: invalid signature")
+	}
+	if crc32.ChecksumIEEE(sig[12:32]) != binary.LittleEndian.Uint32(sig[8:12]) {
+		return nil, errors.New("7z: start header checksum mismatch")
+	}
+	hoff := binary.LittleEndian.Uint64(sig[12:20])
+	hsize := binary.LittleEndian.Uint64(sig[20:28])
+	if hoff > uint64(size) || hsize > uint64(size)-hoff || 32+hoff+hsize > uint64(size) {
+		return nil, errors.New("7z: archive is truncated")
+	}
+	header := make([]byte, hsize)
+	if _, err := r.ReadAt(header, int64(32+hoff)); err != nil {
+		return nil, fmt.Errorf("7z: %w", err)
+	}
+	if crc32.ChecksumIEEE(header) != binary.LittleEndian.Uint32(sig[28:32]) {
+		return nil, errors.New("7z: header checksum mismatch")
+	}
+
+	a := &SevenZipArchive{
+		r:      r,
+		idx:    -1,
+		folder: -1,
+	}
+	if hsize == 0 {
+		// empty archive
+		a.streams = &szStreams{}
+		return a, nil
+	}
+
+	s := &szbuf{b: header}
+	for {
+		id := s.byte()
+		if id == szHeader {
+			break
+		}
+		if id != szEncodedHeader || s.err != nil {
+			return nil, errSzCorrupt
+		}
+		// the header is itself compressed
+		st := s.streamsInfo()
+		if s.err != nil {
+			return nil, s.err
+		}
+		if len(st.folders) == 0 {
+			return nil, errSzCorrupt
+		}
+		limit := uint64(size) * szHeaderRatio
+		if limit > szMaxHeader || limit/szHeaderRatio != uint64(size) {
+			limit = szMaxHeader
+		}
+		data, err := szDecodeFolder(r, st, 0, limit)
+		if err != nil {
+			return nil, err
+		}
+		s = &szbuf{b: data}
+	}
+
+	for s.err == nil {
+		id := s.byte()
+		switch id {
+		case szArchiveProperties:
+			for s.err == nil && s.byte() != szEnd {
+				s.bytes(s.number())
+			}
+		case szAdditionalStreamsInfo:
+			s.streamsInfo()
+		case szMainStreamsInfo:
+			a.streams = s.streamsInfo()
+		case szFilesInfo:
+			a.files = s.filesInfo()
+		case szEnd:
+		default:
+			s.fail(errSzCorrupt)
+		}
+		if id == szEnd {
+			break
+		}
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if a.streams == nil {
+		a.streams = &szStreams{}
+	}
+
+	// directories first, then empty files, then files with data
+	for i, f := range a.files {
+		if !f.stream && f.dir {
+			a.order = append(a.order, i)
+			a.sub = append(a.sub, -1)
+		}
+	}
+	for i, f := range a.files {
+		if !f.stream && !f.dir {
+			a.order = append(a.order, i)
+			a.sub = append(a.sub, -1)
+		}
+	}
+	k := 0
+	for i, f := range a.files {
+		if f.stream {
+			if k >= len(a.streams.sizes) {
+				return nil, errSzCorrupt
+			}
+			a.order = append(a.order, i)
+			a.sub = append(a.sub, k)
+			k++
+		}
+	}
+	return a, nil
+}
+
+func (a *SevenZipArchive) Next() (File, error) {
+	a.idx++
+	if a.idx >= len(a.order) {
+		return File{}, io.EOF
+	}
+	e := a.files[a.order[a.idx]]
+	k := a.sub[a.idx]
+
+	if k >= 0 {
+		fi := a.streams.subFolder[k]
+		if fi != a.folder {
+			fr, err := szFolderReader(a.r, a.streams, fi)
+			if err != nil {
+				return File{}, err
+			}
+			a.fr = fr
+			a.folder = fi
+		} else if _, err := io.CopyN(io.Discard, a.fr, a.left); err != nil {
+			// skip the unread part of the previous file in this folder
+			return File{}, fmt.Errorf("7z: %w", err)
+		}
+		a.left = int64(a.streams.sizes[k])
+		a.crc = crc32.NewIEEE()
+		a.wantCRC = a.streams.crcs[k]
+		a.checkCRC = a.streams.hasCRC[k]
+	} else {
+		a.left = 0
+		a.checkCRC = false
+	}
+
+	name := strings.ReplaceAll(e.name, "\\", "/")
+	dir := e.dir || (e.hasAttrib && e.attrib&szAttrDirectory != 0)
+	f := File{
+		Name: name,
+		Mode: 0644,
+		Type: TypeNormal,
+	}
+	if dir {
+		f.Name += "/"
+		f.Mode = 0755
+		f.Type = TypeDir
+	}
+	if e.hasAttrib && e.attrib&szAttrUnixExt != 0 {
+		// the high 16 bits contain the unix mode
+		mode := e.attrib >> 16
+		f.Mode = cpioMode(mode)
+		switch mode & cpioTypeMask {
+		case cpioSymlink:
+			target, err := io.ReadAll(szFile{a})
+			if err != nil {
+				return File{}, err
+			}
+			f.Type = TypeSymlink
+			f.LinkName = string(target)
+		case cpioChar, cpioBlock, cpioFifo:
+			f.Type = TypeDevice
+		}
+	}
+	return f, nil
+}
+
+func (a *SevenZipArchive) Open() (io.Reader, error) {
+	return szFile{a}, nil
+}
+
+// szFile reads the data of the current file in a 7z archive, and checks its
+// CRC once all of it has been read.
+type szFile struct {
+	a *SevenZipArchive
+}
+
+func (f szFile) Read(p []byte) (int, error) {
+	a := f.a
+	if a.left == 0 {
+		if a.checkCRC && a.crc.Sum32() != a.wantCRC {
+			return 0, errors.New("7z: checksum mismatch")
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > a.left {
+		p = p[:a.left]
+	}
+	n, err := a.fr.Read(p)
+	a.left -= int64(n)
+	a.crc.Write(p[:n])
+	if err == io.EOF && a.left > 0 {
+		return n, fmt.Errorf("7z: %w", io.ErrUnexpectedEOF)
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// szNumber encodes a 7z variable length integer.
+func szNumber(v uint64) []byte {
+	for n := 0; n < 8; n++ {
+		if v>>(8*n) < 1<<(7-n) {
+			b := make([]byte, 9)
+			binary.LittleEndian.PutUint64(b[1:], v)
+			b[0] = byte(0xff<<(8-n)) | byte(v>>(8*n))
+			return b[:n+1]
+		}
+	}
+	b := make([]byte, 9)
+	b[0] = 0xff
+	binary.LittleEndian.PutUint64(b[1:], v)
+	return b
+}
+
+func szAppend32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func szJoin(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// szCoderBytes encodes a coder with one input and output.
+func szCoderBytes(id string, props []byte) []byte {
+	flags := byte(len(id))
+	if props != nil {
+		flags |= 0x20
+	}
+	b := szJoin([]byte{flags}, []byte(id))
+	if props != nil {
+		b = szJoin(b, szNumber(uint64(len(props))), props)
+	}
+	return b
+}
+
+// szTestFile is a file to store in a test 7z archive. Files with data are
+// stored in a single folder, in order.
+type szTestFile struct {
+	name string
+	data string
+	dir  bool
+}
+
+// szStreamsBytes returns the streams info for a folder with the encoded
+// 'folder' (the coders and bind pairs), that decodes 'packed' to files with
+// the given sizes.
+func szStreamsBytes(folder, packed []byte, unpackSizes []uint64, sizes []uint64, crcs []uint32) []byte {
+	h := szJoin([]byte{szPackInfo}, szNumber(0), szNumber(1), []byte{szSize}, szNumber(uint64(len(packed))), []byte{szEnd})
+	h = szJoin(h, []byte{szUnpackInfo, szFolderInfo}, szNumber(1), []byte{0}, folder, []byte{szCodersUnpackSize})
+	for _, size := range unpackSizes {
+		h = szJoin(h, szNumber(size))
+	}
+	h = szJoin(h, []byte{szEnd, szSubStreamsInfo, szNumUnpackStream}, szNumber(uint64(len(sizes))), []byte{szSize})
+	for _, size := range sizes[:len(sizes)-1] {
+		h = szJoin(h, szNumber(size))
+	}
+	h = szJoin(h, []byte{szCRC, 1})
+	for _, crc := range crcs {
+		h = szAppend32(h, crc)
+	}
+	return szJoin(h, []byte{szEnd, szEnd})
+}
+
+// szHeaderBytes returns a header for 'files', whose data is stored in a
+// folder that decodes 'packed'.
+func szHeaderBytes(folder, packed []byte, unpackSizes []uint64, files []szTestFile) []byte {
+	var sizes []uint64
+	var crcs []uint32
+	var empty []bool
+	var dirs []bool
+	for _, f := range files {
+		empty = append(empty, f.data == "")
+		if f.data != "" {
+			sizes = append(sizes, uint64(len(f.data)))
+			crcs = append(crcs, crc32.ChecksumIEEE([]byte(f.data)))
+		} else {
+			dirs = append(dirs, f.dir)
+		}
+	}
+
+	h := []byte{szHeader}
+	if len(sizes) > 0 {
+		h = szJoin(h, []byte{szMainStreamsInfo}, szStreamsBytes(folder, packed, unpackSizes, sizes, crcs))
+	}
+	h = szJoin(h, []byte{szFilesInfo}, szNumber(uint64(len(files))))
+	bits := func(v []bool) []byte {
+		b := make([]byte, (len(v)+7)/8)
+		for i, set := range v {
+			if set {
+				b[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		return b
+	}
+	if len(sizes) < len(files) {
+		isFile := make([]bool, len(dirs))
+		for i, d := range dirs {
+			isFile[i] = !d
+		}
+		p := bits(empty)
+		h = szJoin(h, []byte{szEmptyStream}, szNumber(uint64(len(p))), p)
+		p = bits(isFile)
+		h = szJoin(h, []byte{szEmptyFile}, szNumber(uint64(len(p))), p)
+	}
+	names := []byte{0}
+	for _, f := range files {
+		for _, c := range utf16.Encode([]rune(f.name)) {
+			names = append(names, byte(c), byte(c>>8))
+		}
+		names = append(names, 0, 0)
+	}
+	h = szJoin(h, []byte{szName}, szNumber(uint64(len(names))), names)
+	return szJoin(h, []byte{szEnd, szEnd})
+}
+
+// make7z returns a 7z archive with the packed streams in 'body' and 'header'.
+func make7z(body, header []byte) []byte {
+	start := make([]byte, 20)
+	binary.LittleEndian.PutUint64(start[0:], uint64(len(body)))
+	binary.LittleEndian.PutUint64(start[8:], uint64(len(header)))
+	binary.LittleEndian.PutUint32(start[16:], crc32.ChecksumIEEE(header))
+	sig := szJoin(sevenZipMagic, []byte{0, 4}, szAppend32(nil, crc32.ChecksumIEEE(start)), start)
+	return szJoin(sig, body, header)
+}
+
+func lzmaPack(t *testing.T, data []byte) (props, packed []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := lzma.WriterConfig{
+		DictCap:      1 << 16,
+		SizeInHeader: true,
+		Size:         int64(len(data)),
+	}.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 7z stores the properties of the classic header separately, without
+	// the size
+	b := buf.Bytes()
+	return b[:5], b[lzma.HeaderLen:]
+}
+
+func lzma2Pack(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := lzma.Writer2Config{DictCap: 1 << 20}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// read7z returns the files in a 7z archive and the contents of the regular
+// ones.
+func read7z(data []byte) ([]File, map[string]string, error) {
+	ar, err := NewSevenZipArchive(bytes.NewReader(data), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []File
+	contents := make(map[string]string)
+	for {
+		f, err := ar.Next()
+		if err == io.EOF {
+			return files, contents, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, f)
+		if f.Type == TypeNormal {
+			r, err := ar.Open()
+			if err != nil {
+				return nil, nil, err
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return nil, nil, err
+			}
+			contents[f.Name] = string(b)
+		}
+	}
+}
+
+func TestSevenZip(t *testing.T) {
+	tool := strings.Repeat("tool binary ", 100)
+	readme := strings.Repeat("readme ", 50)
+	solid := []byte(tool + readme)
+	files := []szTestFile{
+		{name: "tool", dir: true},
+		{name: "tool/bin/tool", data: tool},
+		{name: "tool/README", data: readme},
+		{name: "tool/empty"},
+	}
+	single := files[1:2]
+
+	props, lzmaData := lzmaPack(t, []byte(tool))
+	solidProps, solidData := lzmaPack(t, solid)
+	lzma2Data := lzma2Pack(t, []byte(tool))
+	copyFolder := szJoin(szNumber(1), szCoderBytes("\x00", nil))
+	lzma2Folder := szJoin(szNumber(1), szCoderBytes("\x21", []byte{16}))
+
+	tests := []struct {
+		name  string
+		data  []byte
+		files []szTestFile
+	}{
+		{
+			"copy",
+			make7z([]byte(tool), szHeaderBytes(copyFolder, []byte(tool), []uint64{uint64(len(tool))}, single)),
+			single,
+		},
+		{
+			"lzma",
+			make7z(lzmaData, szHeaderBytes(szJoin(szNumber(1), szCoderBytes("\x03\x01\x01", props)), lzmaData, []uint64{uint64(len(tool))}, single)),
+			single,
+		},
+		{
+			"lzma2",
+			make7z(lzma2Data, szHeaderBytes(lzma2Folder, lzma2Data, []uint64{uint64(len(tool))}, single)),
+			single,
+		},
+		{
+			"solid",
+			make7z(solidData, szHeaderBytes(szJoin(szNumber(1), szCoderBytes("\x03\x01\x01", solidProps)), solidData, []uint64{uint64(len(solid))}, files)),
+			files,
+		},
+		{
+			"empty",
+			make7z(nil, szHeaderBytes(nil, nil, nil, files[:1])),
+			files[:1],
+		},
+	}
+
+	// the header can be compressed in the same way as the files
+	plain := szHeaderBytes(copyFolder, []byte(tool), []uint64{uint64(len(tool))}, single)
+	hprops, hpacked := lzmaPack(t, plain)
+	encoded := szJoin([]byte{szEncodedHeader, szPackInfo}, szNumber(uint64(len(tool))), szNumber(1), []byte{szSize}, szNumber(uint64(len(hpacked))), []byte{szEnd},
+		[]byte{szUnpackInfo, szFolderInfo}, szNumber(1), []byte{0}, szNumber(1), szCoderBytes("\x03\x01\x01", hprops),
+		[]byte{szCodersUnpackSize}, szNumber(uint64(len(plain))), []byte{szCRC, 1}, szAppend32(nil, crc32.ChecksumIEEE(plain)), []byte{szEnd, szEnd})
+	tests = append(tests, struct {
+		name  string
+		data  []byte
+		files []szTestFile
+	}{"encoded header", make7z(szJoin([]byte(tool), hpacked), encoded), single})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, contents, err := read7z(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.files) {
+				t.Fatalf("files = %+v", got)
+			}
+			for _, f := range tt.files {
+				name := f.name
+				if f.dir {
+					name += "/"
+				}
+				found := false
+				for _, g := range got {
+					found = found || g.Name == name
+				}
+				if !found {
+					t.Errorf("%s not found in %+v", name, got)
+				}
+				if !f.dir && contents[name] != f.data {
+					t.Errorf("contents of %s = %q, want %q", name, contents[name], f.data)
+				}
+			}
+		})
+	}
+}
+
+func TestSevenZipBCJ(t *testing.T) {
+	// testdata/bcj.7z holds a file with calls filtered with x86 BCJ and
+	// compressed with LZMA2
+	var want []byte
+	for i := 0; i < 512; i++ {
+		want = append(want, 0x90, 0xe8)
+		want = szAppend32(want, uint32(i*16))
+	}
+	data, err := os.ReadFile("testdata/bcj.7z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, contents, err := read7z(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents["call.bin"] != string(want) {
+		t.Errorf("call.bin was not decoded correctly")
+	}
+}
+
+func TestSevenZipInvalid(t *testing.T) {
+	data := "some data"
+	files := []szTestFile{{name: "file", data: data}}
+	archive := func(folder []byte) []byte {
+		return make7z([]byte(data), szHeaderBytes(folder, []byte(data), []uint64{uint64(len(data)), uint64(len(data))}, files))
+	}
+	copyCoder := szCoderBytes("\x00", nil)
+
+	valid := archive(szJoin(szNumber(1), copyCoder))
+	if _, _, err := read7z(valid[:len(valid)-1]); err == nil {
+		t.Fatal("truncated archive was accepted")
+	}
+
+	// a header that is encrypted with AES
+	aes := szJoin([]byte{szEncodedHeader, szPackInfo}, szNumber(0), szNumber(1), []byte{szSize}, szNumber(uint64(len(data))), []byte{szEnd},
+		[]byte{szUnpackInfo, szFolderInfo}, szNumber(1), []byte{0}, szNumber(1), szCoderBytes("\x06\xf1\x07\x01", []byte{0}),
+		[]byte{szCodersUnpackSize}, szNumber(uint64(len(data))), []byte{szEnd, szEnd})
+
+	// an encoded header in a folder with 'copyCoder' of 'size' bytes, whose
+	// data is 'data'
+	encoded := func(size uint64) []byte {
+		return szJoin([]byte{szEncodedHeader, szPackInfo}, szNumber(0), szNumber(1), []byte{szSize}, szNumber(uint64(len(data))), []byte{szEnd},
+			[]byte{szUnpackInfo, szFolderInfo}, szNumber(1), []byte{0}, szNumber(1), copyCoder,
+			[]byte{szCodersUnpackSize}, szNumber(size), []byte{szEnd, szEnd})
+	}
+	// two folders, but no pack streams for them
+	unpacked := szJoin([]byte{szEncodedHeader, szPackInfo}, szNumber(0), szNumber(0), []byte{szEnd},
+		[]byte{szUnpackInfo, szFolderInfo}, szNumber(2), []byte{0}, szNumber(1), copyCoder, szNumber(1), copyCoder,
+		[]byte{szCodersUnpackSize}, szNumber(1), szNumber(1), []byte{szEnd, szEnd})
+
+	badStart := append([]byte{}, valid...)
+	badStart[12]++
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", valid[:len(valid)-1]},
+		{"start header checksum", badStart},
+		{"not 7z", []byte("PK\x03\x04 this is a zip file, not a 7z file")},
+		{"encrypted header", make7z([]byte(data), aes)},
+		{"no coders", archive(szNumber(0))},
+		{"too many coders", archive(szJoin(szNumber(1000), copyCoder))},
+		{"no coder inputs", archive(szJoin(szNumber(1), []byte{0x11, 0}, szNumber(0), szNumber(1)))},
+		{"bind pair input out of range", archive(szJoin(szNumber(2), copyCoder, copyCoder, szNumber(5), szNumber(1)))},
+		{"bind pair output out of range", archive(szJoin(szNumber(2), copyCoder, copyCoder, szNumber(0), szNumber(5)))},
+		{"bind pair cycle", archive(szJoin(szNumber(3), copyCoder, copyCoder, copyCoder, szNumber(0), szNumber(1), szNumber(1), szNumber(1)))},
+		{"packed stream out of range", archive(szJoin(szNumber(1), []byte{0x11, 0}, szNumber(2), szNumber(1), szNumber(0), szNumber(7)))},
+		{"unknown property", make7z(nil, []byte{szHeader, 0x42, szEnd})},
+		{"folders without pack streams", make7z([]byte(data), unpacked)},
+		{"encoded header size out of range", make7z([]byte(data), encoded(1<<62))},
+		{"encoded header too large", make7z([]byte(data), encoded(1<<30))},
+		{"encoded header larger than its data", make7z([]byte(data), encoded(uint64(len(data))+1))},
+		{"too many files", make7z(nil, szJoin([]byte{szHeader, szFilesInfo}, szNumber(1<<40), []byte{szEnd, szEnd}))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := read7z(tt.data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSevenZipFolderCycle(t *testing.T) {
+	// bind pairs that form a cycle are rejected when the folder is parsed,
+	// and the reader also checks for them
+	f := &szFolder{
+		coders:      []szCoder{{id: []byte{0}, numIn: 1}, {id: []byte{0}, numIn: 1}},
+		bindPairs:   []szBindPair{{in: 0, out: 1}, {in: 1, out: 0}},
+		unpackSizes: []uint64{1, 1},
+	}
+	if _, err := f.outReader(0, nil, make([]bool, 2)); !errors.Is(err, errSzCorrupt) {
+		t.Errorf("err = %v, want %v", err, errSzCorrupt)
+	}
+}
+
+func TestSevenZipFolderPackBounds(t *testing.T) {
+	// the second folder's pack streams are past the end of the pack sizes
+	st := &szStreams{folders: []*szFolder{
+		{packed: []int{0}},
+		{packed: []int{0}, firstPack: 1},
+	}}
+	if _, err := szFolderReader(bytes.NewReader(nil), st, 1); err != errSzCorrupt {
+		t.Errorf("err = %v, want %v", err, errSzCorrupt)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// A Format is a compression, archive, or executable format that can be
+// recognized from the start of a file.
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatGzip
+	FormatBzip2
+	FormatXz
+	FormatZstd
+	FormatLz4
+	FormatZip
+	FormatTar
+	FormatAr
+	Format7z
+	FormatCpio
+	FormatRpm
+	FormatExec
+)
+
+var formatNames = map[Format]string{
+	FormatUnknown: "unknown",
+	FormatGzip:    "gzip",
+	FormatBzip2:   "bzip2",
+	FormatXz:      "xz",
+	FormatZstd:    "zstd",
+	FormatLz4:     "lz4",
+	FormatZip:     "zip",
+	FormatTar:     "tar",
+	FormatAr:      "ar",
+	Format7z:      "7z",
+	FormatCpio:    "cpio",
+	FormatRpm:     "rpm",
+	FormatExec:    "executable",
+}
+
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// Compressed returns true if the format is a compression format (it contains
+// a single stream of data).
+func (f Format) Compressed() bool {
+	switch f {
+	case FormatGzip, FormatBzip2, FormatXz, FormatZstd, FormatLz4:
+		return true
+	}
+	return false
+}
+
+// decompressor returns the function used to decompress a compression format.
+func (f Format) decompressor() DecompFn {
+	switch f {
+	case FormatGzip:
+		return gunzipper
+	case FormatBzip2:
+		return b2unzipper
+	case FormatXz:
+		return xunzipper
+	case FormatZstd:
+		return zstdunzipper
+	case FormatLz4:
+		return newLz4Reader
+	}
+	return nounzipper
+}
+
+// the number of bytes needed to recognize any format (tar archives are
+// identified by a magic string at offset 257)
+const sniffLen = 512
+
+var magics = []struct {
+	format Format
+	offset int
+	magic  string
+}{
+	{FormatGzip, 0, "\x1f\x8b"},
+	{FormatBzip2, 0, "BZh"},
+	{FormatXz, 0, "\xfd7zXZ\x00"},
+	{FormatZstd, 0, "\x28\xb5\x2f\xfd"},
+	{FormatLz4, 0, "\x04\x22\x4d\x18"},
+	{FormatZip, 0, "PK\x03\x04"},
+	{FormatZip, 0, "PK\x05\x06"},
+	{FormatTar, 257, "ustar"},
+	{FormatAr, 0, "!<arch>\n"},
+	{Format7z, 0, "7z\xbc\xaf\x27\x1c"},
+	{FormatCpio, 0, "070701"},
+	{FormatCpio, 0, "070702"},
+	{FormatRpm, 0, "\xed\xab\xee\xdb"},
+	{FormatExec, 0, "\x7fELF"},
+	{FormatExec, 0, "\xfe\xed\xfa\xce"},
+	{FormatExec, 0, "\xfe\xed\xfa\xcf"},
+	{FormatExec, 0, "\xce\xfa\xed\xfe"},
+	{FormatExec, 0, "\xcf\xfa\xed\xfe"},
+	{FormatExec, 0, "\xca\xfe\xba\xbe"},
+	{FormatExec, 0, "MZ"},
+}
+
+// Sniff determines the format of data from its first bytes.
+func Sniff(head []byte) Format {
+	for _, m := range magics {
+		if len(head) >= m.offset+len(m.magic) && string(head[m.offset:m.offset+len(m.magic)]) == m.magic {
+			return m.format
+		}
+	}
+	return FormatUnknown
+}
+
+// SniffArchive determines the compression and container format of src. If
+// the data is compressed, the start of the decompressed data is examined to
+// find the container, otherwise the compression is FormatUnknown.
+func SniffArchive(src Source) (compression Format, container Format) {
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(sourceReader(src), head)
+	f := Sniff(head[:n])
+	if !f.Compressed() {
+		return FormatUnknown, f
+	}
+
+	dr, err := f.decompressor()(sourceReader(src))
+	if err != nil {
+		return f, FormatUnknown
+	}
+	n, _ = io.ReadFull(dr, head)
+	return f, Sniff(head[:n])
+}
+
+// decompressed returns an archive function that decompresses all of the data
+// to a spool before opening it with 'fn'. This is used for archive formats
+// that are not read sequentially. The data is only decompressed once for the
+// archives that are open at the same time, such as while nested archives are
+// searched, and the spool is removed when the last of them is closed.
+func decompressed(fn ArchiveFn) ArchiveFn {
+	var spool *Spool
+	open := 0
+	release := func() {
+		if open--; open == 0 {
+			spool.Close()
+			spool = nil
+		}
+	}
+	return func(src Source, decomp DecompFn) (Archive, error) {
+		if spool == nil {
+			dr, err := decomp(sourceReader(src))
+			if err != nil {
+				return nil, err
+			}
+			s := NewSpool()
+			if _, err := io.Copy(s, dr); err != nil {
+				s.Close()
+				return nil, err
+			}
+			spool = s
+		}
+		open++
+		ar, err := fn(spool, nounzipper)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		return &spooledArchive{Archive: ar, release: release}, nil
+	}
+}
+
+// A spooledArchive is an archive that is read from decompressed data in a
+// spool, which is released when the archive is closed.
+type spooledArchive struct {
+	Archive
+	release func()
+}
+
+func (s *spooledArchive) Close() error {
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+	return nil
+}
+
+// closeArchive releases the resources of an archive, if it has any.
+func closeArchive(ar Archive) {
+	if c, ok := ar.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// hasTarSuffix returns true if the filename indicates a (possibly compressed)
+// tar archive.
+func hasTarSuffix(filename string) bool {
+	return strings.Contains(filename, ".tar") || strings.HasSuffix(filename, ".tgz") ||
+		strings.HasSuffix(filename, ".tbz") || strings.HasSuffix(filename, ".txz")
+}
+
+// sniffExtractor constructs an extractor based on the contents of src. It
+// returns nil if the format could not be determined.
+func sniffExtractor(src Source, filename string, tool string, chooser Chooser) Extractor {
+	comp, container := SniffArchive(src)
+	decomp := comp.decompressor()
+
+	var ar ArchiveFn
+	switch container {
+	case FormatTar:
+		ar = NewTarArchive
+	case FormatCpio:
+		ar = NewCpioArchive
+	case FormatZip:
+		ar = NewZipArchive
+	case Format7z:
+		ar = NewSevenZipArchive
+	case FormatAr:
+		ar = NewDebArchive
+	case FormatRpm:
+		ar = NewRpmArchive
+	case FormatExec:
+		return &SingleFileExtractor{
+			Rename:     tool,
+			Name:       filename,
+			Decompress: decomp,
+		}
+	default:
+		if comp == FormatUnknown || hasTarSuffix(filename) {
+			// inconclusive (old tar archives have no magic string)
+			return nil
+		}
+		// a single compressed file
+		return &SingleFileExtractor{
+			Rename:     tool,
+			Name:       filename,
+			Decompress: decomp,
+		}
+	}
+
+	if comp != FormatUnknown && container != FormatTar && container != FormatCpio {
+		ar = decompressed(ar)
+	}
+	return &ArchiveExtractor{
+		File:       chooser,
+		Ar:         ar,
+		Decompress: decomp,
+	}
+}
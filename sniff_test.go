@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// v7Tar returns a tar archive without the ustar magic string, as written by
+// old versions of tar.
+func v7Tar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	data := makeTar(t, entries)
+	hdr := data[:512]
+	for i := 257; i < 265; i++ {
+		hdr[i] = 0
+	}
+	for i := 148; i < 156; i++ {
+		hdr[i] = ' '
+	}
+	sum := 0
+	for _, b := range hdr {
+		sum += int(b)
+	}
+	copy(hdr[148:], fmt.Sprintf("%06o\x00", sum))
+	return data
+}
+
+func TestSniffExtractor(t *testing.T) {
+	tarball := makeTar(t, []tarEntry{
+		{name: "tool-1.0/tool", typ: tar.TypeReg, mode: 0755, data: "tar binary"},
+	})
+	zipped := makeZip(t, map[string][]byte{"tool": []byte("zip binary")})
+	old := v7Tar(t, []tarEntry{{name: "tool", typ: tar.TypeReg, mode: 0755, data: "old binary"}})
+
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		single   bool
+		want     string
+	}{
+		{"unsuffixed gzip tarball", "tool-linux-amd64", makeGzip(t, tarball), false, "tar binary"},
+		{"zip inside gzip", "tool-linux-amd64.gz", makeGzip(t, zipped), false, "zip binary"},
+		{"compressed executable", "tool-linux-amd64", makeGzip(t, []byte("\x7fELF binary")), true, "\x7fELF binary"},
+		// old tar archives can only be recognized by their suffix
+		{"tar suffix", "tool.tar", old, false, "old binary"},
+		{"compressed tar suffix", "tool.tar.gz", makeGzip(t, old), false, "old binary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := bytes.NewReader(tt.data)
+			e := NewExtractor(tt.filename, "tool", &BinaryChooser{Tool: "tool"}, src)
+			if _, ok := e.(*SingleFileExtractor); ok != tt.single {
+				t.Fatalf("extractor = %T", e)
+			}
+			bin, bins, err := e.Extract(src, false)
+			if err != nil {
+				t.Fatalf("%v (candidates %v)", err, bins)
+			}
+			out := filepath.Join(t.TempDir(), "out")
+			if err := bin.Extract(out); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := os.ReadFile(out); string(b) != tt.want {
+				t.Errorf("extracted %q, want %q", b, tt.want)
+			}
+		})
+	}
+
+	if e := sniffExtractor(bytes.NewReader(old), "tool.tar", "tool", &BinaryChooser{Tool: "tool"}); e != nil {
+		t.Errorf("an old tar archive was recognized as %T", e)
+	}
+}
+
+func TestDecompressedSpool(t *testing.T) {
+	data := makeGzip(t, makeZip(t, map[string][]byte{"tool": []byte("binary")}))
+	calls := 0
+	decomp := func(r io.Reader) (io.Reader, error) {
+		calls++
+		return gunzipper(r)
+	}
+
+	fn := decompressed(NewZipArchive)
+	a, err := fn(bytes.NewReader(data), decomp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fn(bytes.NewReader(data), decomp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("decompressed %d times for archives open at the same time, want 1", calls)
+	}
+
+	closeArchive(a)
+	closeArchive(a)
+	if _, files := readArchive(t, b); files["tool"] != "binary" {
+		t.Errorf("the spool was closed while an archive was open: files = %v", files)
+	}
+	closeArchive(b)
+
+	// the spool was released, so it is decompressed again
+	c, err := fn(bytes.NewReader(data), decomp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeArchive(c)
+	if calls != 2 {
+		t.Errorf("decompressed %d times after the spool was released, want 2", calls)
+	}
+}
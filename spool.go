@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// A Source is the data of a downloaded asset or archive member. It can be
+// read from any offset so that archives can be scanned more than once without
+// holding all of their data in memory.
+type Source interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// the amount of data a spool keeps in memory before moving it to a temporary
+// file
+const spoolMemory = 8 << 20
+
+// A Spool stores the data written to it in memory until it grows larger than
+// spoolMemory, after which everything is stored in a temporary file. A Spool
+// is a Source for the data written so far.
+type Spool struct {
+	buf  []byte
+	file *os.File
+	size int64
+}
+
+func NewSpool() *Spool {
+	return &Spool{}
+}
+
+func (s *Spool) Write(p []byte) (int, error) {
+	if s.file == nil && len(s.buf)+len(p) > spoolMemory {
+		f, err := os.CreateTemp("", "eget-*")
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		atExit(func() { s.Close() })
+		if _, err := f.Write(s.buf); err != nil {
+			return 0, err
+		}
+		s.buf = nil
+	}
+
+	if s.file != nil {
+		n, err := s.file.Write(p)
+		s.size += int64(n)
+		return n, err
+	}
+	s.buf = append(s.buf, p...)
+	s.size += int64(len(p))
+	return len(p), nil
+}
+
+func (s *Spool) ReadAt(p []byte, off int64) (int, error) {
+	if s.file != nil {
+		return s.file.ReadAt(p, off)
+	}
+	if off >= int64(len(s.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *Spool) Size() int64 {
+	return s.size
+}
+
+// Close releases the data stored in the spool and removes its temporary file.
+func (s *Spool) Close() error {
+	s.buf = nil
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	err := os.Remove(s.file.Name())
+	s.file = nil
+	return err
+}
+
+// sourceReader returns a reader for all the data in src.
+func sourceReader(src Source) io.Reader {
+	return io.NewSectionReader(src, 0, src.Size())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	for _, size := range []int{0, 100, spoolMemory, spoolMemory + 1, 2*spoolMemory + 12345} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+
+		s := NewSpool()
+		// write in uneven pieces so that the switch to a file happens in the
+		// middle of a write
+		for rest := data; len(rest) > 0; {
+			n := 1<<20 + 3
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := s.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if s.Size() != int64(size) {
+			t.Errorf("size = %d, want %d", s.Size(), size)
+		}
+		if (s.file != nil) != (size > spoolMemory) {
+			t.Errorf("size %d: stored in a file = %v", size, s.file != nil)
+		}
+
+		got, err := io.ReadAll(sourceReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: data does not match", size)
+		}
+		if size > 10 {
+			// reads at an offset, and past the end
+			p := make([]byte, 10)
+			if n, err := s.ReadAt(p, int64(size-5)); n != 5 || err != io.EOF || !bytes.Equal(p[:5], data[size-5:]) {
+				t.Errorf("size %d: ReadAt past end = %d, %v", size, n, err)
+			}
+		}
+
+		var name string
+		if s.file != nil {
+			name = s.file.Name()
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if name != "" {
+			if _, err := os.Stat(name); !os.IsNotExist(err) {
+				t.Errorf("temporary file %s was not removed", name)
+			}
+		}
+	}
+}
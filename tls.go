@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zyedidia/eget/home"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion returns the TLS version for a version such as "1.2", or 0
+// (the default) for the empty string.
+func parseTLSVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version %s (must be 1.0, 1.1, 1.2 or 1.3)", s)
+	}
+	return v, nil
+}
+
+// loadCAs returns the system's certificate pool with the certificates in
+// 'paths' added, which are PEM files or directories of them. It returns nil if
+// there are no paths, so the system pool is used as usual.
+func loadCAs(paths []string) (*x509.CertPool, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, p := range paths {
+		p, err := home.Expand(p)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("%s: no PEM certificates found", p)
+			}
+			continue
+		}
+		// files in a directory that are not certificates are skipped, as
+		// with the system's certificate directories
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(p, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if pool.AppendCertsFromPEM(data) {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: no PEM certificates found", p)
+		}
+	}
+	return pool, nil
+}
+
+// loadClientCert loads a client certificate and its key from PEM files. The
+// key may be in the same file as the certificate.
+func loadClientCert(cert, key string) (*tls.Certificate, error) {
+	if key == "" {
+		key = cert
+	}
+	cert, err := home.Expand(cert)
+	if err != nil {
+		return nil, err
+	}
+	key, err = home.Expand(key)
+	if err != nil {
+		return nil, err
+	}
+	c, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("client certificate: %w", err)
+	}
+	return &c, nil
+}
+
+// newTransport returns an HTTP transport that uses the TLS options, and
+// 'cert' as the client certificate if it is not nil.
+func newTransport(cert *tls.Certificate) *http.Transport {
+	conf := &tls.Config{
+		RootCAs:            opts.RootCAs,
+		MinVersion:         opts.MinTLS,
+		InsecureSkipVerify: opts.DisableSSL,
+	}
+	if cert != nil {
+		conf.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer().DialContext,
+		TLSClientConfig:       conf,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// A tlsTransport sends requests to hosts that have a client certificate with
+// a transport that presents it, and other requests with the base transport.
+type tlsTransport struct {
+	base  *http.Transport
+	hosts map[string]*http.Transport
+}
+
+func newTLSTransport() *tlsTransport {
+	t := &tlsTransport{
+		base:  newTransport(nil),
+		hosts: make(map[string]*http.Transport),
+	}
+	for host, auth := range opts.Hosts {
+		if auth.ClientCert != nil {
+			t.hosts[host] = newTransport(auth.ClientCert)
+		}
+	}
+	return t
+}
+
+func (t *tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, host := range []string{req.URL.Host, req.URL.Hostname()} {
+		if tr, ok := t.hosts[host]; ok {
+			return tr.RoundTrip(req)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newClients makes requests use new HTTP clients with the current options,
+// and the clients that were cached before are used again after the test.
+func newClients(t *testing.T) {
+	t.Helper()
+	clientLock.Lock()
+	saved := clients
+	clients = make(map[bool]*http.Client)
+	clientLock.Unlock()
+	t.Cleanup(func() {
+		clientLock.Lock()
+		clients = saved
+		clientLock.Unlock()
+	})
+}
+
+// writePEM writes PEM blocks of type 'typ' to a file in 'dir'.
+func writePEM(t *testing.T, dir, name, typ string, blocks ...[]byte) string {
+	t.Helper()
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b})...)
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// selfSigned returns a self-signed client certificate and its key.
+func selfSigned(t *testing.T) (cert *x509.Certificate, der []byte, key []byte) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "eget test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, der, key
+}
+
+func getBody(url string) (string, error) {
+	resp, err := Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return string(b), err
+}
+
+func TestCACerts(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	// failed handshakes are expected
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	dir := t.TempDir()
+	ca := writePEM(t, dir, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+
+	for _, path := range []string{ca, dir} {
+		pool, err := loadCAs([]string{path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		setOpts(t, Flags{RootCAs: pool})
+		newClients(t)
+		if body, err := getBody(srv.URL); err != nil || body != "ok" {
+			t.Errorf("with the CA in %s: %q, %v", path, body, err)
+		}
+	}
+
+	// the server's certificate is not trusted without the CA
+	setOpts(t, Flags{})
+	newClients(t)
+	if _, err := getBody(srv.URL); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("without the CA: err = %v, want a certificate error", err)
+	}
+}
+
+func TestLoadCAsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "notes.txt")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0644)
+
+	tests := []struct {
+		path string
+		err  string
+	}{
+		{filepath.Join(dir, "missing.pem"), "no such file"},
+		{notPEM, "no PEM certificates found"},
+		{dir, "no PEM certificates found"},
+	}
+	for _, tt := range tests {
+		if _, err := loadCAs([]string{tt.path}); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("loadCAs(%s): err = %v, want %q", tt.path, err, tt.err)
+		}
+	}
+	if pool, err := loadCAs(nil); pool != nil || err != nil {
+		t.Errorf("loadCAs(nil) = %v, %v, want the system pool", pool, err)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	cert, der, key := selfSigned(t)
+	dir := t.TempDir()
+	certFile := writePEM(t, dir, "client.pem", "CERTIFICATE", der)
+	keyFile := writePEM(t, dir, "client.key", "EC PRIVATE KEY", key)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	auth, err := NewHostAuth(host, ConfigHost{ClientCert: certFile, ClientKey: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setOpts(t, Flags{RootCAs: roots, Hosts: map[string]HostAuth{host: auth}})
+	newClients(t)
+	if body, err := getBody(srv.URL); err != nil || body != "eget test client" {
+		t.Errorf("with the client certificate: %q, %v", body, err)
+	}
+
+	// the server refuses requests without it
+	setOpts(t, Flags{RootCAs: roots})
+	newClients(t)
+	if _, err := getBody(srv.URL); err == nil {
+		t.Error("the server accepted a request without a client certificate")
+	}
+
+	// the key may be in the same file as the certificate
+	both := writePEM(t, dir, "both.pem", "CERTIFICATE", der)
+	f, _ := os.OpenFile(both, os.O_APPEND|os.O_WRONLY, 0)
+	pem.Encode(f, &pem.Block{Type: "EC PRIVATE KEY", Bytes: key})
+	f.Close()
+	if _, err := loadClientCert(both, ""); err != nil {
+		t.Errorf("certificate and key in one file: %v", err)
+	}
+}
+
+func TestClientCertInvalid(t *testing.T) {
+	_, der, _ := selfSigned(t)
+	dir := t.TempDir()
+	certFile := writePEM(t, dir, "client.pem", "CERTIFICATE", der)
+
+	tests := []struct {
+		conf ConfigHost
+		err  string
+	}{
+		{ConfigHost{ClientCert: filepath.Join(dir, "missing.pem")}, "host example.com: client certificate:"},
+		{ConfigHost{ClientCert: certFile, ClientKey: filepath.Join(dir, "missing.key")}, "host example.com: client certificate:"},
+		// the certificate file has no key
+		{ConfigHost{ClientCert: certFile}, "host example.com: client certificate:"},
+		{ConfigHost{ClientKey: certFile}, "client_key requires a client_cert"},
+	}
+	for _, tt := range tests {
+		if _, err := NewHostAuth("example.com", tt.conf); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: err = %v, want %q", tt.conf, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// installedFiles returns the files, directories and symlinks under 'root'.
+func installedFiles(root string) ([]InstalledFile, error) {
+	var files []InstalledFile
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		f := InstalledFile{
+			Path: name,
+		}
+		switch {
+		case d.IsDir():
+			f.Type = "dir"
+		case d.Type()&fs.ModeSymlink != 0:
+			f.Type = "symlink"
+			f.Link, err = os.Readlink(name)
+		default:
+			f.Type = "file"
+			f.SHA256, err = hashFile(name)
+		}
+		files = append(files, f)
+		return err
+	})
+	return files, err
+}
+
+// recordFiles records the files created by an install. For a versioned
+// install, these are the symlink and the files of the version it links to.
+func recordFiles(in *Install) error {
+	root := in.Target
+	var files []InstalledFile
+	if in.Path != "" {
+		files = append(files, InstalledFile{
+			Path: in.Target,
+			Type: "symlink",
+			Link: in.Path,
+		})
+		root = in.Path
+	}
+	found, err := installedFiles(root)
+	if err != nil {
+		return err
+	}
+	in.Files = append(files, found...)
+	return nil
+}
+
+// installFiles returns the files that an install created. Installs recorded
+// before files were tracked only know their target.
+func installFiles(in *Install) []InstalledFile {
+	if len(in.Files) != 0 {
+		return in.Files
+	}
+	files := []InstalledFile{{Path: in.Target}}
+	if in.Path != "" {
+		files = append(files, InstalledFile{Path: in.Path})
+	}
+	return files
+}
+
+// modified returns why the installed file 'f' may not be removed, or the
+// empty string if it is unchanged since the install. 'recorded' holds the
+// paths of all files being removed, so that a directory can be checked for
+// files that were added to it.
+func modified(f InstalledFile, recorded map[string]bool) string {
+	fi, err := os.Lstat(f.Path)
+	if err != nil {
+		return ""
+	}
+	switch f.Type {
+	case "file":
+		if !fi.Mode().IsRegular() {
+			return "is no longer a file"
+		}
+		sum, err := hashFile(f.Path)
+		if err != nil {
+			return err.Error()
+		}
+		if sum != f.SHA256 {
+			return "was modified since it was installed"
+		}
+	case "symlink":
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			return "is no longer a symlink"
+		}
+		if link, _ := os.Readlink(f.Path); link != f.Link {
+			return fmt.Sprintf("now links to %s", link)
+		}
+	case "dir":
+		if !fi.IsDir() {
+			return "is no longer a directory"
+		}
+		entries, err := os.ReadDir(f.Path)
+		if err != nil {
+			return err.Error()
+		}
+		for _, e := range entries {
+			if !recorded[filepath.Join(f.Path, e.Name())] {
+				return fmt.Sprintf("contains %s, which was not installed", e.Name())
+			}
+		}
+	}
+	return ""
+}
+
+// Uninstall removes everything that 'installs' created, along with their
+// backups, and removes them from the manifest. Nothing is removed if any of
+// the files were changed since they were installed. If 'dryRun' is true, the
+// files that would be removed are returned without removing them.
+func Uninstall(m *Manifest, installs []*Install, dryRun bool) ([]string, error) {
+	var files []InstalledFile
+	for _, in := range installs {
+		files = append(files, installFiles(in)...)
+	}
+	recorded := make(map[string]bool)
+	for _, f := range files {
+		recorded[f.Path] = true
+	}
+
+	var bad []string
+	for _, f := range files {
+		if reason := modified(f, recorded); reason != "" {
+			bad = append(bad, fmt.Sprintf("\n  %s: %s", f.Path, reason))
+		}
+	}
+	if len(bad) != 0 {
+		return nil, fmt.Errorf("refusing to remove files that were changed since they were installed:%s", strings.Join(bad, ""))
+	}
+
+	// remove the contents of directories before the directories
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path > files[j].Path
+	})
+	var removed []string
+	for _, f := range files {
+		if !exists(f.Path) {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(f.Path); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, f.Path)
+	}
+	for _, in := range installs {
+		if in.Backup != "" && exists(in.Backup) {
+			if !dryRun {
+				if err := os.RemoveAll(in.Backup); err != nil {
+					return removed, err
+				}
+			}
+			removed = append(removed, in.Backup)
+		}
+	}
+	if dryRun {
+		return removed, nil
+	}
+
+	for _, in := range installs {
+		if in.Path != "" {
+			// remove the version directory if it is now empty; other
+			// versions are kept until they are pruned
+			os.Remove(filepath.Dir(in.Path))
+		}
+		delete(m.Installs, in.Target)
+	}
+	return removed, m.Save()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordInstall records an install of 'target' in a new manifest.
+func recordInstall(t *testing.T, in *Install) *Manifest {
+	t.Helper()
+	t.Setenv("EGET_DATA", t.TempDir())
+	m, err := LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recordFiles(in); err != nil {
+		t.Fatal(err)
+	}
+	m.Record(in)
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestUninstall(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "tool")
+	os.MkdirAll(filepath.Join(target, "bin"), 0755)
+	os.WriteFile(filepath.Join(target, "bin", "tool"), []byte("binary"), 0755)
+	os.Symlink("bin/tool", filepath.Join(target, "tool"))
+	backup := backupPath(target)
+	os.WriteFile(backup, []byte("old"), 0755)
+
+	in := &Install{Target: target, Backup: backup}
+	m := recordInstall(t, in)
+
+	removed, err := Uninstall(m, []*Install{in}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 5 || !exists(target) {
+		t.Errorf("dry run removed %v", removed)
+	}
+
+	removed, err = Uninstall(m, []*Install{in}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 5 {
+		t.Errorf("removed %v", removed)
+	}
+	if exists(target) || exists(backup) {
+		t.Error("files were left behind")
+	}
+	if len(m.Installs) != 0 {
+		t.Error("install was not removed from the manifest")
+	}
+	// other files in the same directory are kept
+	if !exists(dir) {
+		t.Error("the parent directory was removed")
+	}
+}
+
+func TestUninstallModified(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(target string)
+		reason string
+	}{
+		{"modified file", func(target string) {
+			os.WriteFile(filepath.Join(target, "tool"), []byte("changed"), 0755)
+		}, "was modified"},
+		{"added file", func(target string) {
+			os.WriteFile(filepath.Join(target, "config"), []byte("user data"), 0644)
+		}, "contains config"},
+		{"changed symlink", func(target string) {
+			os.Remove(filepath.Join(target, "link"))
+			os.Symlink("/etc/passwd", filepath.Join(target, "link"))
+		}, "now links to"},
+		{"replaced file", func(target string) {
+			os.Remove(filepath.Join(target, "tool"))
+			os.Mkdir(filepath.Join(target, "tool"), 0755)
+		}, "no longer a file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "tool")
+			os.MkdirAll(target, 0755)
+			os.WriteFile(filepath.Join(target, "tool"), []byte("binary"), 0755)
+			os.Symlink("tool", filepath.Join(target, "link"))
+			in := &Install{Target: target}
+			m := recordInstall(t, in)
+
+			tt.change(target)
+			_, err := Uninstall(m, []*Install{in}, false)
+			if err == nil || !strings.Contains(err.Error(), tt.reason) {
+				t.Fatalf("err = %v, want %q", err, tt.reason)
+			}
+			// nothing is removed
+			if !exists(filepath.Join(target, "link")) || len(m.Installs) != 1 {
+				t.Error("files were removed")
+			}
+		})
+	}
+}
+
+func TestUninstallVersioned(t *testing.T) {
+	t.Setenv("EGET_DATA", t.TempDir())
+	m, err := LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "tool")
+	repo := "user/tool"
+	installVersion(t, m, repo, "v1", target)
+	in := installVersion(t, m, repo, "v2", target)
+	if err := recordFiles(in); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Uninstall(m, []*Install{in}, false); err != nil {
+		t.Fatal(err)
+	}
+	if exists(target) || exists(filepath.Dir(in.Path)) {
+		t.Error("the link or the active version was left behind")
+	}
+	// other versions are kept until they are pruned
+	v1, _ := versionDir(repo, "v1")
+	if !exists(v1) {
+		t.Error("an inactive version was removed")
+	}
+}
@@ -3,18 +3,49 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
+	"debug/macho"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"path"
+	"strings"
 )
 
+// A Verifier checks the data written to it, which is written as it is
+// downloaded. Verify is called once all of the data has been written.
 type Verifier interface {
-	Verify(b []byte) error
+	io.Writer
+	Verify() error
+}
+
+// sha256Hasher computes the SHA-256 checksum of the data written to it.
+type sha256Hasher struct {
+	h hash.Hash
+}
+
+func (s *sha256Hasher) Write(b []byte) (int, error) {
+	if s.h == nil {
+		s.h = sha256.New()
+	}
+	return s.h.Write(b)
+}
+
+func (s *sha256Hasher) Sum() []byte {
+	if s.h == nil {
+		s.h = sha256.New()
+	}
+	return s.h.Sum(nil)
 }
 
 type NoVerifier struct{}
 
-func (n *NoVerifier) Verify(b []byte) error {
+func (n *NoVerifier) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (n *NoVerifier) Verify() error {
 	return nil
 }
 
@@ -28,6 +59,7 @@ func (e *Sha256Error) Error() string {
 }
 
 type Sha256Verifier struct {
+	sha256Hasher
 	Expected []byte
 }
 
@@ -41,30 +73,32 @@ func NewSha256Verifier(expectedHex string) (*Sha256Verifier, error) {
 	}, nil
 }
 
-func (s256 *Sha256Verifier) Verify(b []byte) error {
-	sum := sha256.Sum256(b)
-	if bytes.Equal(sum[:], s256.Expected) {
+func (s256 *Sha256Verifier) Verify() error {
+	sum := s256.Sum()
+	if bytes.Equal(sum, s256.Expected) {
 		return nil
 	}
 	return &Sha256Error{
 		Expected: s256.Expected,
-		Got:      sum[:],
+		Got:      sum,
 	}
 }
 
-type Sha256Printer struct{}
+type Sha256Printer struct {
+	sha256Hasher
+}
 
-func (s256 *Sha256Printer) Verify(b []byte) error {
-	sum := sha256.Sum256(b)
-	fmt.Printf("%x\n", sum)
+func (s256 *Sha256Printer) Verify() error {
+	fmt.Printf("%x\n", s256.Sum())
 	return nil
 }
 
 type Sha256AssetVerifier struct {
+	sha256Hasher
 	AssetURL string
 }
 
-func (s256 *Sha256AssetVerifier) Verify(b []byte) error {
+func (s256 *Sha256AssetVerifier) Verify() error {
 	resp, err := Get(s256.AssetURL)
 	if err != nil {
 		return err
@@ -79,12 +113,109 @@ func (s256 *Sha256AssetVerifier) Verify(b []byte) error {
 	if n < sha256.Size {
 		return fmt.Errorf("sha256sum (%s) too small: %d bytes decoded", string(data), n)
 	}
-	sum := sha256.Sum256(b)
-	if bytes.Equal(sum[:], expected[:n]) {
+	sum := s256.Sum()
+	if bytes.Equal(sum, expected[:n]) {
 		return nil
 	}
 	return &Sha256Error{
 		Expected: expected[:n],
-		Got:      sum[:],
+		Got:      sum,
+	}
+}
+
+// A Sha256SumsVerifier verifies an asset using a checksums file that lists
+// the SHA-256 checksums of many assets, in the format used by sha256sum.
+type Sha256SumsVerifier struct {
+	sha256Hasher
+	AssetURL string // URL of the checksums file
+	Name     string // name of the asset in the checksums file
+}
+
+func (s256 *Sha256SumsVerifier) Verify() error {
+	resp, err := Get(s256.AssetURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		// a '*' before the name marks a file hashed in binary mode
+		name := strings.TrimPrefix(fields[1], "*")
+		if path.Base(name) != s256.Name {
+			continue
+		}
+		expected, err := hex.DecodeString(fields[0])
+		if err != nil || len(expected) != sha256.Size {
+			return fmt.Errorf("invalid sha256sum for %s: %s", s256.Name, fields[0])
+		}
+		sum := s256.Sum()
+		if bytes.Equal(sum, expected) {
+			return nil
+		}
+		return &Sha256Error{
+			Expected: expected,
+			Got:      sum,
+		}
+	}
+	return fmt.Errorf("%s is not listed in %s", s256.Name, path.Base(s256.AssetURL))
+}
+
+// a map from GOARCH values to Mach-O CPU types
+var machocpus = map[string]macho.Cpu{
+	"amd64": macho.CpuAmd64,
+	"386":   macho.Cpu386,
+	"arm":   macho.CpuArm,
+	"arm64": macho.CpuArm64,
+}
+
+// VerifyMachO checks that the file is a Mach-O binary (either fat or thin)
+// containing code for one of the given GOARCH architectures, which are in
+// order of preference, and returns the architecture that the binary will run
+// as.
+func VerifyMachO(file string, archs []string) (string, error) {
+	var cpus []macho.Cpu
+	for _, arch := range archs {
+		cpu, ok := machocpus[arch]
+		if !ok {
+			return "", fmt.Errorf("no Mach-O cpu type for %s", arch)
+		}
+		cpus = append(cpus, cpu)
+	}
+
+	var have []macho.Cpu
+	ff, err := macho.OpenFat(file)
+	if errors.Is(err, macho.ErrNotFat) {
+		f, err := macho.Open(file)
+		if err != nil {
+			return "", fmt.Errorf("%s is not a Mach-O binary: %w", file, err)
+		}
+		defer f.Close()
+		have = append(have, f.Cpu)
+	} else if err != nil {
+		return "", fmt.Errorf("%s is not a Mach-O binary: %w", file, err)
+	} else {
+		defer ff.Close()
+		for _, a := range ff.Arches {
+			have = append(have, a.Cpu)
+		}
+	}
+
+	for i, cpu := range cpus {
+		for _, h := range have {
+			if h == cpu {
+				return archs[i], nil
+			}
+		}
+	}
+	if len(have) == 1 {
+		return "", fmt.Errorf("%s is a %v binary, not %v", file, have[0], cpus[0])
 	}
+	return "", fmt.Errorf("universal binary %s does not contain %v code", file, cpus[0])
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// thinMachO returns a 64-bit Mach-O header with no load commands.
+func thinMachO(cpu macho.Cpu) []byte {
+	b := make([]byte, 32)
+	binary.LittleEndian.PutUint32(b[0:], macho.Magic64)
+	binary.LittleEndian.PutUint32(b[4:], uint32(cpu))
+	binary.LittleEndian.PutUint32(b[12:], uint32(macho.TypeExec))
+	return b
+}
+
+// fatMachO returns a universal binary containing thin headers for 'cpus'.
+func fatMachO(cpus ...macho.Cpu) []byte {
+	const align = 64
+	header := 8 + 20*len(cpus)
+	b := make([]byte, header)
+	binary.BigEndian.PutUint32(b[0:], macho.MagicFat)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(cpus)))
+	for i, cpu := range cpus {
+		for len(b)%align != 0 {
+			b = append(b, 0)
+		}
+		thin := thinMachO(cpu)
+		arch := b[8+20*i:]
+		binary.BigEndian.PutUint32(arch[0:], uint32(cpu))
+		binary.BigEndian.PutUint32(arch[8:], uint32(len(b)))
+		binary.BigEndian.PutUint32(arch[12:], uint32(len(thin)))
+		binary.BigEndian.PutUint32(arch[16:], 6)
+		b = append(b, thin...)
+	}
+	return b
+}
+
+func TestVerifyMachO(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		archs []string
+		want  string
+		err   bool
+	}{
+		{"thin native", thinMachO(macho.CpuArm64), []string{"arm64", "amd64"}, "arm64", false},
+		{"thin rosetta", thinMachO(macho.CpuAmd64), []string{"arm64", "amd64"}, "amd64", false},
+		{"thin wrong", thinMachO(macho.CpuAmd64), []string{"arm64"}, "", true},
+		{"fat native", fatMachO(macho.CpuAmd64, macho.CpuArm64), []string{"arm64", "amd64"}, "arm64", false},
+		{"fat rosetta", fatMachO(macho.CpuAmd64), []string{"arm64", "amd64"}, "amd64", false},
+		{"fat wrong", fatMachO(macho.CpuAmd64), []string{"arm64"}, "", true},
+		{"not macho", []byte("#!/bin/sh\n"), []string{"arm64"}, "", true},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(p, tt.data, 0755); err != nil {
+				t.Fatal(err)
+			}
+			got, err := VerifyMachO(p, tt.archs)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("arch = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
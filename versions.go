@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Versioned installs keep each version of a tool in its own directory,
+// '<data>/tools/<repo>/<tag>/', and the installed file is a symlink to the
+// active version.
+
+// versionDir returns the directory that holds version 'tag' of 'repo'.
+func versionDir(repo, tag string) (string, error) {
+	dir, err := repoVersionsDir(repo)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, tagDirName(tag)), nil
+}
+
+// tagDirName returns the name of the directory for version 'tag'. Tags may
+// contain slashes, as in 'kustomize/v5.0.0', so they are escaped to keep each
+// version in a single directory directly inside the repository's directory.
+func tagDirName(tag string) string {
+	name := url.PathEscape(tag)
+	if name == "." || name == ".." {
+		name = strings.ReplaceAll(name, ".", "%2E")
+	}
+	return name
+}
+
+// tagFromDirName returns the tag of a version directory called 'name'.
+func tagFromDirName(name string) string {
+	tag, err := url.PathUnescape(name)
+	if err != nil {
+		return name
+	}
+	return tag
+}
+
+// checkRepo returns an error if 'repo' is not exactly 'owner/name', so that
+// its versions directory is always two levels inside 'tools'.
+func checkRepo(repo string) error {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid repository %q (must be owner/name)", repo)
+	}
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, `\:`) {
+			return fmt.Errorf("invalid repository %q (must be owner/name)", repo)
+		}
+	}
+	return nil
+}
+
+func repoVersionsDir(repo string) (string, error) {
+	if err := checkRepo(repo); err != nil {
+		return "", err
+	}
+	data, err := GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(data, "tools", filepath.FromSlash(repo)), nil
+}
+
+// repoName returns the 'user/repo' name of a repository given as a name or a
+// GitHub URL.
+func repoName(target string) string {
+	if IsGithubUrl(target) {
+		_, after, _ := Cut(target, "github.com/")
+		return strings.TrimSuffix(strings.Trim(after, "/"), ".git")
+	}
+	return target
+}
+
+// releaseTag returns the tag of the release that a finder found, if it is
+// known.
+func releaseTag(f Finder) string {
+	switch f := f.(type) {
+	case *GithubAssetFinder:
+		return f.Release
+	case *GithubSourceFinder:
+		return f.Tag
+	}
+	return ""
+}
+
+// projectName returns the name of the repository a finder searches, or
+// 'target' if it does not search a repository.
+func projectName(f Finder, target string) string {
+	switch f := f.(type) {
+	case *GithubAssetFinder:
+		return f.Repo
+	case *GithubSourceFinder:
+		return f.Repo
+	}
+	return target
+}
+
+// LinkVersion makes 'link' a symlink to 'dest'. The link is replaced
+// atomically. If 'link' exists and is not a symlink, it is kept as a backup
+// in the same way as in Stage.Commit, and the path of the backup is returned.
+func LinkVersion(link, dest string) (string, error) {
+	tmp := filepath.Join(filepath.Dir(link), "."+filepath.Base(link)+".eget-link")
+	os.Remove(tmp)
+	if err := os.Symlink(dest, tmp); err != nil {
+		return "", err
+	}
+
+	var backup string
+	var err error
+	if fi, lerr := os.Lstat(link); lerr == nil && fi.Mode()&os.ModeSymlink == 0 {
+		backup = backupPath(link)
+		if err := os.RemoveAll(backup); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+		err = replace(tmp, link, backup)
+	} else {
+		err = os.Rename(tmp, link)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return backup, nil
+}
+
+// versionedInstalls returns the versioned installs of 'repo'.
+func versionedInstalls(m *Manifest, repo string) []*Install {
+	var found []*Install
+	for _, in := range m.Find(repo) {
+		if in.Path != "" {
+			found = append(found, in)
+		}
+	}
+	return found
+}
+
+// ListVersions returns the versions of 'repo' that are installed, and which
+// of them are active.
+func ListVersions(m *Manifest, repo string) ([]string, map[string]bool, error) {
+	dir, err := repoVersionsDir(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("no versions of %s are installed", repo)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var tags []string
+	for _, e := range entries {
+		if e.IsDir() {
+			tags = append(tags, tagFromDirName(e.Name()))
+		}
+	}
+	sort.Strings(tags)
+
+	active := make(map[string]bool)
+	for _, in := range versionedInstalls(m, repo) {
+		active[in.Tag] = true
+	}
+	return tags, active, nil
+}
+
+// UseVersion switches the versioned installs of 'repo' to version 'tag',
+// which must already be installed.
+func UseVersion(m *Manifest, repo, tag string) ([]*Install, error) {
+	installs := versionedInstalls(m, repo)
+	if len(installs) == 0 {
+		return nil, fmt.Errorf("no versioned installs of %s found", repo)
+	}
+	dir, err := versionDir(repo, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists(dir) {
+		return nil, fmt.Errorf("version %s of %s is not installed", tag, repo)
+	}
+	// check that every file exists before switching any of them
+	for _, in := range installs {
+		if !exists(filepath.Join(dir, filepath.Base(in.Path))) {
+			return nil, fmt.Errorf("%s is not installed for version %s of %s", filepath.Base(in.Path), tag, repo)
+		}
+	}
+	for _, in := range installs {
+		dest := filepath.Join(dir, filepath.Base(in.Path))
+		if _, err := LinkVersion(in.Target, dest); err != nil {
+			return nil, err
+		}
+		in.Path = dest
+		in.Tag = tag
+		if err := recordFiles(in); err != nil {
+			return nil, err
+		}
+	}
+	return installs, m.Save()
+}
+
+// PruneVersions removes the installed versions of 'repo' that are not
+// active, and returns the directories that were removed.
+func PruneVersions(m *Manifest, repo string) ([]string, error) {
+	tags, active, err := ListVersions(m, repo)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, tag := range tags {
+		if active[tag] {
+			continue
+		}
+		dir, err := versionDir(repo, tag)
+		if err != nil {
+			return removed, err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, err
+		}
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTagDirName(t *testing.T) {
+	for _, tag := range []string{"v1.0.0", "kustomize/v5.0.0", `a\b`, "100%", ".", "..", "v1 beta"} {
+		name := tagDirName(tag)
+		if name == "." || name == ".." || filepath.Base(name) != name {
+			t.Errorf("tagDirName(%q) = %q, which is not a single directory", tag, name)
+		}
+		if got := tagFromDirName(name); got != tag {
+			t.Errorf("tagFromDirName(%q) = %q, want %q", name, got, tag)
+		}
+	}
+	if tagDirName("v1.0.0") != "v1.0.0" {
+		t.Errorf("plain tags are escaped")
+	}
+}
+
+// installVersion writes 'file' for version 'tag' of 'repo', and links
+// 'target' to it.
+func installVersion(t *testing.T, m *Manifest, repo, tag, target string) *Install {
+	t.Helper()
+	dir, err := versionDir(repo, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, filepath.Base(target))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(tag), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LinkVersion(target, path); err != nil {
+		t.Fatal(err)
+	}
+	in := &Install{Target: target, Project: repo, Tag: tag, Path: path}
+	m.Record(in)
+	return in
+}
+
+func TestVersions(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("EGET_DATA", data)
+	m, err := LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	target := filepath.Join(bin, "kustomize")
+	repo := "kubernetes-sigs/kustomize"
+
+	installVersion(t, m, repo, "kustomize/v4.5.7", target)
+	installVersion(t, m, repo, "kustomize/v5.0.0", target)
+
+	// each tag has its own directory, even though it contains a slash
+	entries, err := os.ReadDir(filepath.Join(data, "tools", "kubernetes-sigs", "kustomize"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d version directories, want 2", len(entries))
+	}
+
+	tags, active, err := ListVersions(m, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kustomize/v4.5.7", "kustomize/v5.0.0"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("versions = %v, want %v", tags, want)
+	}
+	if !active["kustomize/v5.0.0"] || active["kustomize/v4.5.7"] {
+		t.Errorf("active = %v", active)
+	}
+
+	if _, err := UseVersion(m, repo, "kustomize/v4.5.7"); err != nil {
+		t.Fatal(err)
+	}
+	if readString(t, target) != "kustomize/v4.5.7" {
+		t.Errorf("target = %q after switching versions", readString(t, target))
+	}
+
+	// pruning keeps the active version
+	removed, err := PruneVersions(m, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 {
+		t.Errorf("removed %v, want one version", removed)
+	}
+	if readString(t, target) != "kustomize/v4.5.7" {
+		t.Errorf("the active version was removed")
+	}
+	tags, _, _ = ListVersions(m, repo)
+	if want := []string{"kustomize/v4.5.7"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("versions after pruning = %v, want %v", tags, want)
+	}
+}
+
+func TestLinkVersionFile(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "tool")
+	dest := filepath.Join(dir, "tool-v2")
+	os.WriteFile(link, []byte("v1"), 0755)
+	os.WriteFile(dest, []byte("v2"), 0755)
+	old, _ := os.Stat(link)
+
+	backup, err := LinkVersion(link, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != dest {
+		t.Errorf("link = %q, %v", target, err)
+	}
+	// the file was kept as a backup by linking it before the symlink was
+	// renamed over it
+	if fi, err := os.Stat(backup); err != nil || !os.SameFile(fi, old) {
+		t.Errorf("backup %s is not the previous file", backup)
+	}
+
+	// replacing a symlink doesn't make a backup
+	dest3 := filepath.Join(dir, "tool-v3")
+	os.WriteFile(dest3, []byte("v3"), 0755)
+	backup, err = LinkVersion(link, dest3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != "" || readString(t, link) != "v3" {
+		t.Errorf("backup = %q, link = %q", backup, readString(t, link))
+	}
+	if exists(filepath.Join(dir, ".tool.eget-link")) {
+		t.Error("temporary link was left behind")
+	}
+}
+
+func TestVersionsInvalidRepo(t *testing.T) {
+	root := t.TempDir()
+	data := filepath.Join(root, "data")
+	t.Setenv("EGET_DATA", data)
+	precious := filepath.Join(root, "precious")
+	os.MkdirAll(precious, 0755)
+	os.WriteFile(filepath.Join(precious, "file"), []byte("keep"), 0644)
+	m, err := LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	installVersion(t, m, "user/tool", "v1", filepath.Join(t.TempDir(), "tool"))
+
+	for _, repo := range []string{"../..", "..", "user", "user/tool/extra", "/tool", "user/", "./tool", "user/..", `user\..`} {
+		if _, _, err := ListVersions(m, repo); err == nil {
+			t.Errorf("listed the versions of %q", repo)
+		}
+		if _, err := UseVersion(m, repo, "v1"); err == nil {
+			t.Errorf("used a version of %q", repo)
+		}
+		if _, err := PruneVersions(m, repo); err == nil {
+			t.Errorf("pruned the versions of %q", repo)
+		}
+	}
+	if !exists(filepath.Join(precious, "file")) || !exists(data) {
+		t.Error("files outside of the versions directory were removed")
+	}
+}